@@ -4,11 +4,15 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"flag"
 	"fmt"
+	"io/fs"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -16,26 +20,48 @@ import (
 	"notcoin_contest/internal/handler"
 	"notcoin_contest/internal/service"
 	"notcoin_contest/internal/store"
+	"notcoin_contest/internal/telemetry"
+	"notcoin_contest/migrations"
 
 	_ "github.com/lib/pq"
 	"github.com/redis/go-redis/v9"
 )
 
+// Build metadata, set at build time with
+// -ldflags "-X main.version=... -X main.commit=... -X main.builtAt=...".
+var (
+	version = "dev"
+	commit  = "unknown"
+	builtAt = "unknown"
+)
+
 type application struct {
-	config        *config.Config
-	logger        *log.Logger
-	db            *sql.DB
-	redisClient   *redis.Client
-	saleService   *service.SaleService
-	server        *http.Server
-	shutdownChan  chan struct{}
-	schedulerDone chan struct{}
+	config *config.Config
+	// configPath is the -config flag, re-read on SIGHUP.
+	configPath  string
+	logger      *log.Logger
+	db          *sql.DB
+	replicaDB   *sql.DB
+	redisClient redis.UniversalClient
+	redisStore  *store.RedisStore
+	saleService *service.SaleService
+	// shutdownTracing flushes buffered spans and stops the exporter.
+	shutdownTracing func(context.Context) error
+	server          *http.Server
+	shutdownChan    chan struct{}
+	schedulerDone   chan struct{}
+	// ready is set once warmup has finished; /readyz reports it.
+	ready atomic.Bool
 }
 
 func main() {
 	logger := log.New(os.Stdout, "", log.Ldate|log.Ltime|log.Lshortfile)
 
-	cfg, err := config.LoadConfig()
+	configPath := flag.String("config", "", "path to a JSON config file (overrides CONFIG_FILE)")
+	selfTest := flag.Bool("selftest", false, "run a checkout and purchase against the database and Redis, then exit")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig(*configPath)
 	if err != nil {
 		logger.Fatalf("Failed to load configuration: %v", err)
 	}
@@ -48,52 +74,135 @@ func main() {
 	if err != nil {
 		logger.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer func() {
-		if err := db.Close(); err != nil {
-			logger.Printf("Error closing database: %v", err)
-		}
-	}()
 
-	if err := store.RunMigrations(db, cfg.MigrationsDir); err != nil {
+	migrationsFS := fs.FS(migrations.FS)
+	if cfg.MigrationsDir != "" {
+		migrationsFS = os.DirFS(cfg.MigrationsDir)
+	}
+	if err := store.RunMigrations(db, migrationsFS); err != nil {
 		logger.Fatalf("Failed to run migrations: %v", err)
 	}
 
-	redisClient, err := store.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB)
+	var replicaDB *sql.DB
+	var replicaStore *store.DBStore
+	if cfg.DBReplicaDataSourceName != "" {
+		replicaDB, err = store.ConnectDB(cfg.DBDriver, cfg.DBReplicaDataSourceName)
+		if err != nil {
+			logger.Fatalf("Failed to connect to database replica: %v", err)
+		}
+		replicaStore = store.NewDBStore(replicaDB)
+		logger.Println("Serving read-only endpoints from the database replica.")
+	}
+
+	redisClient, err := store.NewRedisClient(cfg.RedisAddr, cfg.RedisPassword, cfg.RedisDB, store.RedisClientOptions{
+		Mode:        cfg.RedisMode,
+		Addrs:       cfg.RedisAddrs,
+		MasterName:  cfg.RedisMasterName,
+		MaxRetries:  cfg.RedisMaxRetries,
+		DialTimeout: cfg.RedisDialTimeout,
+		ReadTimeout: cfg.RedisReadTimeout,
+	})
 	if err != nil {
 		logger.Fatalf("Failed to connect to Redis: %v", err)
 	}
-	defer func() {
-		if err := redisClient.Close(); err != nil {
-			logger.Printf("Error closing Redis client: %v", err)
+
+	if *selfTest {
+		err := runSelfTest(logger, cfg, store.NewDBStore(db), store.NewRedisStore(redisClient, cfg.RedisKeyPrefix, cfg.RedisOpTimeout))
+		redisClient.Close()
+		if replicaDB != nil {
+			replicaDB.Close()
 		}
-	}()
+		db.Close()
+		if err != nil {
+			logger.Fatalf("Self-test failed: %v", err)
+		}
+		logger.Println("Self-test passed.")
+		return
+	}
+
+	shutdownTracing, err := telemetry.SetupTracing(context.Background(), cfg.OTLPEndpoint)
+	if err != nil {
+		logger.Fatalf("Failed to set up tracing: %v", err)
+	}
 
 	dbStore := store.NewDBStore(db)
-	redisStore := store.NewRedisStore(redisClient)
-	saleService := service.NewSaleService(logger, dbStore, redisStore, cfg)
+	redisStore := store.NewRedisStore(redisClient, cfg.RedisKeyPrefix, cfg.RedisOpTimeout)
+	saleService := service.NewSaleService(logger, dbStore, replicaStore, redisStore, cfg)
 
 	app := &application{
-		config:        cfg,
-		logger:        logger,
-		db:            db,
-		redisClient:   redisClient,
-		saleService:   saleService,
-		shutdownChan:  make(chan struct{}),
-		schedulerDone: make(chan struct{}),
+		config:          cfg,
+		configPath:      *configPath,
+		logger:          logger,
+		db:              db,
+		replicaDB:       replicaDB,
+		redisClient:     redisClient,
+		redisStore:      redisStore,
+		saleService:     saleService,
+		shutdownTracing: shutdownTracing,
+		shutdownChan:    make(chan struct{}),
+		schedulerDone:   make(chan struct{}),
 	}
 
 	go app.runSaleScheduler()
+	go app.runRedisHealthCheck()
+	go app.warmup()
+	go app.handleReloads()
 
 	mux := http.NewServeMux()
-	checkoutHandler := handler.NewCheckoutHandler(logger, saleService)
+	checkoutHandler := handler.NewCheckoutHandler(logger, saleService, cfg.TrustedProxies)
+	checkoutStatusHandler := handler.NewCheckoutStatusHandler(logger, saleService)
 	purchaseHandler := handler.NewPurchaseHandler(logger, saleService)
+	purchaseBatchHandler := handler.NewPurchaseBatchHandler(logger, saleService)
+	itemsHandler := handler.NewItemsHandler(logger, saleService, cfg.MaxPageSize, cfg.RejectOversizedPage)
+	saleStatusHandler := handler.NewSaleStatusHandler(logger, saleService)
+	nextSaleHandler := handler.NewNextSaleHandler(logger, saleService)
+	itemHandler := handler.NewItemHandler(logger, saleService)
+	recentPurchasesHandler := handler.NewRecentPurchasesHandler(logger, saleService)
+	leaderboardHandler := handler.NewLeaderboardHandler(logger, saleService)
+	userLimitHandler := handler.NewUserLimitHandler(logger, saleService)
+	userSalesHandler := handler.NewUserSalesHandler(logger, saleService)
+	adminHandler := handler.NewAdminHandler(logger, saleService, cfg.TrustedProxies)
+
+	handle := func(pattern string, h http.Handler) {
+		mux.Handle(pattern, handler.Trace(pattern, h))
+	}
 
-	mux.Handle("/checkout", checkoutHandler)
-	mux.Handle("/purchase", purchaseHandler)
+	handle("GET /{$}", handler.ServiceInfo(version))
+	handle("GET /version", handler.Version(handler.BuildInfo{Version: version, Commit: commit, BuiltAt: builtAt}))
+	handle("GET /healthz", http.HandlerFunc(handler.Healthz))
+	handle("GET /readyz", handler.Readyz(app.ready.Load))
+	handle("/checkout", handler.Maintenance(logger, saleService, checkoutHandler))
+	handle("GET /checkout/status", checkoutStatusHandler)
+	handle("/purchase", handler.Maintenance(logger, saleService, purchaseHandler))
+	handle("POST /purchase/batch", handler.Maintenance(logger, saleService, purchaseBatchHandler))
+	handle("/items", handler.Gzip(cfg.GzipMinSize, itemsHandler))
+	handle("GET /sales/active", saleStatusHandler)
+	handle("GET /sales/next", nextSaleHandler)
+	handle("GET /sales/{id}/items/{itemID}", itemHandler)
+	handle("GET /sales/{id}/recent", recentPurchasesHandler)
+	handle("GET /sales/{id}/leaderboard", leaderboardHandler)
+	handle("GET /users/{userID}/limit", userLimitHandler)
+	handle("GET /users/{userID}/sales", userSalesHandler)
+	handle("GET /admin/sales/{id}/checkouts-by-ip", handler.RequireAdmin(cfg.AdminToken, handler.Gzip(cfg.GzipMinSize, http.HandlerFunc(adminHandler.CheckoutsByIP))))
+	handle("GET /admin/sales/{id}/items", handler.RequireAdmin(cfg.AdminToken, handler.Gzip(cfg.GzipMinSize, http.HandlerFunc(adminHandler.SaleItems))))
+	handle("GET /admin/sales/{id}/stats", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.SaleStats)))
+	handle("POST /admin/sales/{id}/reimage", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.Reimage)))
+	handle("POST /admin/sales/deactivate-all", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.DeactivateAll)))
+	handle("DELETE /admin/sales/{id}", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.PurgeSale)))
+	handle("GET /admin/reports", handler.RequireAdmin(cfg.AdminToken, handler.Gzip(cfg.GzipMinSize, http.HandlerFunc(adminHandler.Reports))))
+	handle("GET /admin/db-stats", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.DBStats)))
+	handle("GET /admin/funnel", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.Funnel)))
+	handle("POST /admin/items/{itemID}/disable", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.DisableItem)))
+	handle("POST /admin/users/{userID}/release", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.ReleaseUserReservations)))
+	handle("GET /admin/user-lists/{list}", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.GetUserList)))
+	handle("PUT /admin/user-lists/{list}/{userID}", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.AddToUserList)))
+	handle("DELETE /admin/user-lists/{list}/{userID}", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.RemoveFromUserList)))
+	handle("GET /admin/maintenance", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.GetMaintenance)))
+	handle("PUT /admin/maintenance", handler.RequireAdmin(cfg.AdminToken, http.HandlerFunc(adminHandler.SetMaintenance)))
 
 	app.server = &http.Server{
 		Addr:         fmt.Sprintf(":%d", cfg.ServerPort),
-		Handler:      mux,
+		Handler:      handler.RequestID(handler.AccessLog(logger, cfg.AccessLogExcludePaths, handler.Recover(logger, handler.NotFound(mux)))),
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -104,11 +213,18 @@ func main() {
 }
 
 func (app *application) serve() {
-	app.logger.Printf("Starting server on %s", app.server.Addr)
-
 	errChan := make(chan error)
 	go func() {
-		if err := app.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		var err error
+		if app.config.TLSEnabled() {
+			// ListenAndServeTLS negotiates HTTP/2 over ALPN automatically.
+			app.logger.Printf("Starting server with TLS on %s", app.server.Addr)
+			err = app.server.ListenAndServeTLS(app.config.TLSCertFile, app.config.TLSKeyFile)
+		} else {
+			app.logger.Printf("Starting server on %s", app.server.Addr)
+			err = app.server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
 			errChan <- err
 		}
 	}()
@@ -123,6 +239,9 @@ func (app *application) serve() {
 		app.logger.Printf("Received signal %s. Shutting down server...", sig)
 	}
 
+	// Every step below shares one 30s budget, in this order: stop background
+	// jobs, stop accepting and drain requests, flush telemetry, then close
+	// the connections the earlier steps were still using.
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -135,18 +254,45 @@ func (app *application) serve() {
 		app.logger.Println("Sale scheduler did not stop in time.")
 	}
 
+	app.logger.Println("Stopping the server and draining in-flight requests...")
 	if err := app.server.Shutdown(ctx); err != nil {
 		app.logger.Printf("Graceful server shutdown failed: %v", err)
 	} else {
 		app.logger.Println("Server gracefully stopped.")
 	}
 
+	app.logger.Println("Flushing traces...")
+	if err := app.shutdownTracing(ctx); err != nil {
+		app.logger.Printf("Error flushing traces: %v", err)
+	} else {
+		app.logger.Println("Traces flushed.")
+	}
+
+	app.logger.Println("Closing Redis and database connections...")
+	if err := app.redisClient.Close(); err != nil {
+		app.logger.Printf("Error closing Redis client: %v", err)
+	}
+	if app.replicaDB != nil {
+		if err := app.replicaDB.Close(); err != nil {
+			app.logger.Printf("Error closing database replica: %v", err)
+		}
+	}
+	if err := app.db.Close(); err != nil {
+		app.logger.Printf("Error closing database: %v", err)
+	}
+
 	app.logger.Println("Application shut down complete.")
 }
 
 func (app *application) runSaleScheduler() {
 	defer close(app.schedulerDone)
 
+	// An active sale left without items would otherwise survive the initial
+	// cycle, which keeps an existing active sale.
+	if err := app.saleService.DeactivateEmptyActiveSale(context.Background()); err != nil {
+		app.logger.Printf("Scheduler: Error checking the active sale for items: %v", err)
+	}
+
 	app.logger.Println("Scheduler: Running initial sale cycle management.")
 	if err := app.saleService.ManageHourlySaleCycle(context.Background()); err != nil {
 		app.logger.Printf("Scheduler: Error during initial sale cycle management: %v", err)
@@ -155,7 +301,37 @@ func (app *application) runSaleScheduler() {
 	ticker := time.NewTicker(app.config.SaleCycleInterval)
 	defer ticker.Stop()
 
-	app.logger.Printf("Sale scheduler started. Will run every %s.", app.config.SaleCycleInterval.String())
+	reaperTicker := time.NewTicker(app.config.SaleReaperInterval)
+	defer reaperTicker.Stop()
+
+	// reconcile stays nil when reconciliation is off.
+	var reconcile <-chan time.Time
+	if app.config.SoldReconcileInterval > 0 {
+		reconcileTicker := time.NewTicker(app.config.SoldReconcileInterval)
+		defer reconcileTicker.Stop()
+		reconcile = reconcileTicker.C
+	}
+
+	// leaderboard stays nil when the leaderboard cache is off.
+	var leaderboard <-chan time.Time
+	if app.config.LeaderboardRefreshInterval > 0 {
+		leaderboardTicker := time.NewTicker(app.config.LeaderboardRefreshInterval)
+		defer leaderboardTicker.Stop()
+		leaderboard = leaderboardTicker.C
+	}
+
+	// prewarm fires SalePrewarmLead before the next cycle; it stays nil
+	// when pre-warming is off.
+	var prewarm <-chan time.Time
+	schedulePrewarm := func() {
+		if app.config.SalePrewarmLead > 0 {
+			prewarm = time.After(app.config.SaleCycleInterval - app.config.SalePrewarmLead)
+		}
+	}
+	schedulePrewarm()
+
+	app.logger.Printf("Sale scheduler started. Will run every %s, reaping ended sales every %s.",
+		app.config.SaleCycleInterval.String(), app.config.SaleReaperInterval.String())
 
 	for {
 		select {
@@ -164,9 +340,105 @@ func (app *application) runSaleScheduler() {
 			if err := app.saleService.ManageHourlySaleCycle(context.Background()); err != nil {
 				app.logger.Printf("Scheduler: Error during hourly sale cycle management: %v", err)
 			}
+			schedulePrewarm()
+		case <-prewarm:
+			prewarm = nil
+			if err := app.saleService.PrepareNextSale(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error preparing the next sale: %v", err)
+			}
+		case <-reaperTicker.C:
+			if err := app.saleService.ReapEndedSales(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error reaping ended sales: %v", err)
+			}
+			if err := app.saleService.DeactivateEmptyActiveSale(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error checking the active sale for items: %v", err)
+			}
+			if err := app.saleService.PurgeRetiredItems(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error purging items of retired sales: %v", err)
+			}
+			if err := app.saleService.ReturnExpiredMysteryItems(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error returning expired items to the mystery pool: %v", err)
+			}
+		case <-reconcile:
+			if err := app.saleService.ReconcileSoldItems(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error reconciling sold items: %v", err)
+			}
+		case <-leaderboard:
+			if err := app.saleService.RefreshLeaderboard(context.Background()); err != nil {
+				app.logger.Printf("Scheduler: Error refreshing the leaderboard: %v", err)
+			}
 		case <-app.shutdownChan:
 			app.logger.Println("Scheduler: Received shutdown signal. Stopping...")
 			return
 		}
 	}
 }
+
+// handleReloads reloads the runtime-tunable settings on SIGHUP until
+// shutdown. A configuration that fails to load or validate is ignored.
+func (app *application) handleReloads() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-hup:
+			next, err := config.LoadConfig(app.configPath)
+			if err != nil {
+				app.logger.Printf("Config reload failed, keeping the current configuration: %v", err)
+				continue
+			}
+			applied, ignored := app.saleService.ReloadConfig(next)
+			if len(applied) == 0 {
+				app.logger.Println("Config reloaded; no runtime-tunable setting changed.")
+			} else {
+				app.logger.Printf("Config reloaded; applied changes to %s.", strings.Join(applied, ", "))
+			}
+			if len(ignored) > 0 {
+				app.logger.Printf("Config reload ignored changes to %s; they take effect after a restart.", strings.Join(ignored, ", "))
+			}
+		case <-app.shutdownChan:
+			return
+		}
+	}
+}
+
+// warmup primes the database and Redis connection pools, then marks the
+// instance ready. A failed warmup is logged and the instance is marked ready
+// anyway, since warming only saves latency.
+func (app *application) warmup() {
+	if n := app.config.WarmupConnections; n > 0 {
+		start := time.Now()
+		if err := app.saleService.Warmup(context.Background(), n); err != nil {
+			app.logger.Printf("Warning: warmup failed: %v", err)
+		} else {
+			app.logger.Printf("Warmed up %d connections in %s.", n, time.Since(start))
+		}
+	}
+	app.ready.Store(true)
+}
+
+func (app *application) runRedisHealthCheck() {
+	ticker := time.NewTicker(app.config.RedisHealthCheckInterval)
+	defer ticker.Stop()
+
+	healthy := true
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), app.config.RedisHealthCheckInterval)
+			err := app.redisStore.Ping(ctx)
+			cancel()
+
+			if err != nil && healthy {
+				app.logger.Printf("Redis health check: Redis became unhealthy: %v", err)
+			} else if err == nil && !healthy {
+				app.logger.Println("Redis health check: Redis is healthy again.")
+			}
+			healthy = err == nil
+		case <-app.shutdownChan:
+			return
+		}
+	}
+}
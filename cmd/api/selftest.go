@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"notcoin_contest/internal/config"
+	"notcoin_contest/internal/service"
+	"notcoin_contest/internal/store"
+)
+
+// selfTestItems is the size of the sale the self-test creates.
+const selfTestItems = 3
+
+// runSelfTest drives a checkout and a purchase through the service against
+// the configured database and Redis, checks the outcome, and deletes its
+// sale again. It refuses to run while a sale is active, so pointing it at a
+// live deployment cannot disturb a running sale.
+func runSelfTest(logger *log.Logger, cfg *config.Config, dbStore *store.DBStore, redisStore *store.RedisStore) (err error) {
+	ctx := context.Background()
+
+	// A tiny sale, open at once, with every optional hurdle switched off.
+	// Reads go to the primary so replica lag cannot fail the checks.
+	testCfg := *cfg
+	testCfg.ItemsPerSale = selfTestItems
+	testCfg.SalePreviewLead = 0
+	testCfg.MysteryMode = false
+	testCfg.PaymentReferenceRequired = false
+	testCfg.MinPurchaseDwell = 0
+	testCfg.PurchaseVelocityLimit = 0
+	testCfg.CheckoutQueueCapacity = 0
+	testCfg.PurchaseReplayTTL = 0
+	testCfg.ItemsCacheTTL = 0
+	saleService := service.NewSaleService(logger, dbStore, nil, redisStore, &testCfg)
+
+	active, _, err := saleService.GetCurrentActiveSale(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check for an active sale: %w", err)
+	}
+	if active != nil {
+		return fmt.Errorf("sale ID %d is active; run the self-test where no sale is running", active.ID)
+	}
+
+	sale, items, err := saleService.CreateNewSaleAndItems()
+	if err == nil && items == nil {
+		return fmt.Errorf("sale ID %d became active while the self-test was starting", sale.ID)
+	}
+	if sale != nil {
+		defer func() {
+			if cleanupErr := saleService.DiscardSale(ctx, sale.ID); cleanupErr != nil {
+				err = errors.Join(err, fmt.Errorf("failed to delete self-test sale ID %d: %w", sale.ID, cleanupErr))
+			}
+		}()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create sale: %w", err)
+	}
+	logger.Printf("Self-test: created sale ID %d with %d items.", sale.ID, len(items))
+
+	userID := fmt.Sprintf("selftest-%d", time.Now().UnixNano())
+	item := items[0]
+
+	checkout, err := saleService.ProcessCheckout(ctx, userID, item.ID, service.CheckoutMeta{})
+	if err != nil {
+		return fmt.Errorf("checkout of item %d failed: %w", item.ID, err)
+	}
+
+	purchased, err := saleService.ProcessPurchase(ctx, checkout.Code, "")
+	if err != nil {
+		return fmt.Errorf("purchase with code %s failed: %w", checkout.Code, err)
+	}
+	if purchased.ID != item.ID {
+		return fmt.Errorf("purchase returned item %d, want %d", purchased.ID, item.ID)
+	}
+
+	if _, err := saleService.ProcessPurchase(ctx, checkout.Code, ""); !errors.Is(err, service.ErrCheckoutCodeAlreadyUsed) {
+		return fmt.Errorf("second purchase with code %s returned %v, want %v", checkout.Code, err, service.ErrCheckoutCodeAlreadyUsed)
+	}
+
+	limit, err := saleService.GetUserLimit(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user limit: %w", err)
+	}
+	if limit.SaleID != sale.ID || limit.Used != 1 {
+		return fmt.Errorf("user limit reports %d purchases in sale %d, want 1 in sale %d", limit.Used, limit.SaleID, sale.ID)
+	}
+
+	sold := true
+	soldItems, err := saleService.GetItemsForSale(ctx, sale.ID, &sold, selfTestItems, 0)
+	if err != nil {
+		return fmt.Errorf("failed to list sold items: %w", err)
+	}
+	if len(soldItems) != 1 || soldItems[0].ID != item.ID {
+		return fmt.Errorf("sale has %d sold items, want only item %d", len(soldItems), item.ID)
+	}
+
+	return nil
+}
@@ -1,43 +1,197 @@
 package config
 
 import (
+    "encoding/json"
     "fmt"
+    "net/netip"
+    "net/url"
     "os"
+    "sort"
     "strconv"
+    "strings"
     "time"
 
     "github.com/joho/godotenv"
+    "golang.org/x/text/currency"
 )
 
+// minCheckoutCodeBytes keeps checkout codes unguessable: codes act as bearer
+// tokens for a reservation.
+const minCheckoutCodeBytes = 8
+
 type Config struct {
-    ServerPort int
+    ServerPort  int
+    TLSCertFile string
+    TLSKeyFile  string
+
+    DBDriver                string
+    DBDataSourceName        string
+    // DBReplicaDataSourceName points read-only endpoints at a replica; empty
+    // means reads use the primary.
+    DBReplicaDataSourceName string
+    PostgresURL             string
+    // DBStatementTimeout is set as each connection's statement_timeout, so
+    // Postgres cancels runaway queries; zero leaves the server default.
+    DBStatementTimeout      time.Duration
+    // MigrationsDir overrides the migrations embedded in the binary.
+    MigrationsDir           string
+
+    RedisAddr      string
+    RedisPassword  string
+    RedisDB        int
+    RedisURL       string
+    RedisKeyPrefix string
 
-    DBDriver         string
-    DBDataSourceName string
-    PostgresURL      string
-    MigrationsDir    string
+    // RedisMode is standalone (RedisAddr), sentinel or cluster; the latter
+    // two connect through RedisAddrs, and sentinel to RedisMasterName.
+    RedisMode       string
+    RedisAddrs      []string
+    RedisMasterName string
 
-    RedisAddr     string
-    RedisPassword string
-    RedisDB       int
-    RedisURL      string
+    RedisMaxRetries          int
+    RedisDialTimeout         time.Duration
+    RedisReadTimeout         time.Duration
+    RedisHealthCheckInterval time.Duration
+    // RedisOpTimeout bounds each Redis operation so a slow Redis cannot eat
+    // the whole request budget; zero leaves only the caller's deadline.
+    RedisOpTimeout           time.Duration
 
-    SaleCycleInterval time.Duration
-    SaleDuration      time.Duration
+    SaleCycleInterval  time.Duration
+    SaleReaperInterval time.Duration
+    SaleDuration       time.Duration
+    // ItemRetention is how long after a sale ends its items and checkout
+    // attempts are kept before the reaper deletes them; zero keeps them.
+    ItemRetention time.Duration
+    // SoldReconcileInterval is how often the active sale's sold_items and
+    // sold-out flag are checked against its items; zero disables it.
+    SoldReconcileInterval time.Duration
+    // LeaderboardRefreshInterval is how often the active sale's leaderboard
+    // is recomputed into Redis; zero disables the cache.
+    LeaderboardRefreshInterval time.Duration
+    SalePreviewLead   time.Duration
+    // SalePrewarmLead is how long before each cycle the next sale is
+    // created, inactive, so the cycle only has to switch it on; zero creates
+    // it at the cycle.
+    SalePrewarmLead   time.Duration
+    SaleTitle         string
+    SaleCategory      string
     CodeTTLExpiry     time.Duration
+    // ItemBatchRetries is how many more times a sale's item insert is tried
+    // after a transient database error.
+    ItemBatchRetries  int
+
+    ImageBaseURL string
+    // ValidateImageURLs refuses items and re-image templates whose image
+    // URL, resolved against ImageBaseURL, is not an http or https URL with
+    // a host.
+    ValidateImageURLs bool
+    // MaxPageSize caps the limit of an /items page. Larger limits are
+    // clamped, or refused when RejectOversizedPage is set.
+    MaxPageSize         int
+    RejectOversizedPage bool
+    // ItemsCacheTTL is how long an /items page may be served from Redis,
+    // and so how stale it may be; zero disables the cache.
+    ItemsCacheTTL time.Duration
+
+    // ItemPriceCents and ItemCurrency price every item of new sales.
+    ItemPriceCents int
+    ItemCurrency   string
+    // ItemSKUCount spreads each new sale's items over that many SKUs, and
+    // MaxItemsPerSKU caps how many items of one SKU a user may buy per
+    // sale; zero disables either.
+    ItemSKUCount   int
+    MaxItemsPerSKU int
+    // PaymentReferenceRequired makes /purchase refuse requests without a
+    // payment_reference to verify.
+    PaymentReferenceRequired bool
+
+    ItemsPerSale          int
+    MaxItemsPerUser       int
+    GlobalUserLimit       int
+    MaxActiveReservations int
+    // CheckoutQueueCapacity is how many checkout codes of a sale may be open
+    // at once before further checkouts wait in a queue; zero disables it.
+    CheckoutQueueCapacity int
+    MysteryMode           bool
+    // RejectOwnedItemCheckout explains a failed checkout of an item the user
+    // already bought, instead of reporting it as merely sold.
+    RejectOwnedItemCheckout bool
+    // RejectInactiveSaleItemCheckout explains a failed checkout of an item
+    // from another sale, such as a stale link, instead of reporting it as
+    // not found.
+    RejectInactiveSaleItemCheckout bool
+    // SellThroughWarning is the fraction of a sale sold, in (0, 1], at which
+    // a one-off warning is logged; zero disables it.
+    SellThroughWarning    float64
+    CheckoutCodeBytes     int
+    // CheckoutCodeEncoding renders checkout codes as hex, base64url or
+    // base58; all three are URL-safe.
+    CheckoutCodeEncoding string
+    // ScarceSaleRemaining is the number of unsold items at or below which a
+    // purchase re-checks a cached checkout code against the database.
+    ScarceSaleRemaining int
+    // PurchaseReplayTTL is how long a purchased code, submitted again,
+    // returns the original purchase instead of failing; zero disables it.
+    PurchaseReplayTTL time.Duration
+
+    // PurchaseVelocityLimit caps purchases per user, across sales, within
+    // PurchaseVelocityWindow; zero disables the check.
+    PurchaseVelocityLimit  int
+    PurchaseVelocityWindow time.Duration
+    // MinPurchaseDwell is the least time that must pass between checkout and
+    // purchase of a code; zero disables the check.
+    MinPurchaseDwell time.Duration
+
+    // PurchaseConcurrency caps concurrent purchase transactions, with up to
+    // PurchaseQueueSize more waiting at most PurchaseQueueTimeout; zero
+    // disables the cap.
+    PurchaseConcurrency  int
+    PurchaseQueueSize    int
+    PurchaseQueueTimeout time.Duration
+    // PurchaseIsolation is the isolation level of the purchase transaction:
+    // read_committed, repeatable_read or serializable.
+    PurchaseIsolation string
+
+    AdminToken     string
+    TrustedProxies []netip.Prefix
+
+    // AnonymizeSecret keys the hash that stands in for buyers in the recent
+    // purchases ticker and the leaderboard.
+    AnonymizeSecret string
+
+    OTLPEndpoint   string
+    FunnelTracking bool
 
-    ItemsPerSale         int
-    MaxItemsPerUser      int
+    GzipMinSize int
+
+    // AccessLogExcludePaths are request paths served without an access log
+    // line, such as health checks.
+    AccessLogExcludePaths []string
+
+    // WarmupConnections is how many database and Redis connections are
+    // primed at startup before /readyz reports ready; zero skips warmup.
+    WarmupConnections int
 }
 
-func LoadConfig() (*Config, error) {
+// LoadConfig builds the configuration from environment variables (including
+// a .env file) layered over an optional JSON config file. The file is read
+// from configPath, or from CONFIG_FILE when configPath is empty.
+func LoadConfig(configPath string) (*Config, error) {
     if err := godotenv.Load(); err != nil {
         fmt.Printf("Warning: Could not load .env file from")
 }
 
+    if configPath == "" {
+        configPath = os.Getenv("CONFIG_FILE")
+    }
+    src, err := newEnvSource(configPath)
+    if err != nil {
+        return nil, err
+    }
+
     config := &Config{}
 
-    if port := os.Getenv("PORT"); port != "" {
+    if port := src.getEnvOrDefault("PORT", ""); port != "" {
         if p, err := strconv.Atoi(port); err == nil {
             config.ServerPort = p
         }
@@ -46,37 +200,504 @@ func LoadConfig() (*Config, error) {
         config.ServerPort = 8032
     }
 
+    config.TLSCertFile = src.getEnvOrDefault("TLS_CERT_FILE", "")
+    config.TLSKeyFile = src.getEnvOrDefault("TLS_KEY_FILE", "")
+
     config.DBDriver = "postgres"
     
-    dbHost := getEnvOrDefault("NOTBACK_DB_HOST", "localhost")
-    dbPort := getEnvOrDefault("NOTBACK_DB_PORT", "5432")
-    dbName := getEnvOrDefault("NOTBACK_DB_DATABASE", "notDB")
-    dbUser := getEnvOrDefault("NOTBACK_DB_USERNAME", "root")
-    dbPassword := getEnvOrDefault("NOTBACK_DB_PASSWORD", "1234")
+    dbHost := src.getEnvOrDefault("NOTBACK_DB_HOST", "localhost")
+    dbPort := src.getEnvOrDefault("NOTBACK_DB_PORT", "5432")
+    dbName := src.getEnvOrDefault("NOTBACK_DB_DATABASE", "notDB")
+    dbUser := src.getEnvOrDefault("NOTBACK_DB_USERNAME", "root")
+    dbPassword := src.getEnvOrDefault("NOTBACK_DB_PASSWORD", "1234")
     
-    config.DBDataSourceName = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?sslmode=disable", 
-        dbUser, dbPassword, dbHost, dbPort, dbName)
+    if config.DBStatementTimeout, err = src.getDurationEnvOrDefault("NOTBACK_DB_STATEMENT_TIMEOUT", 5*time.Second); err != nil {
+        return nil, err
+    }
+    dsnParams := "sslmode=disable"
+    if config.DBStatementTimeout > 0 {
+        // lib/pq sends unknown DSN parameters as session settings.
+        dsnParams += fmt.Sprintf("&statement_timeout=%d", config.DBStatementTimeout.Milliseconds())
+    }
+
+    config.DBDataSourceName = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?%s", 
+        dbUser, dbPassword, dbHost, dbPort, dbName, dsnParams)
     config.PostgresURL = config.DBDataSourceName
-    config.MigrationsDir = getEnvOrDefault("MIGRATIONS_DIR", "migrations")
 
-    redisHost := getEnvOrDefault("NOTBACK_REDIS_HOST", "localhost")
-    redisPort := getEnvOrDefault("NOTBACK_REDIS_PORT", "6379")
+    if replicaHost := src.getEnvOrDefault("NOTBACK_DB_REPLICA_HOST", ""); replicaHost != "" {
+        replicaPort := src.getEnvOrDefault("NOTBACK_DB_REPLICA_PORT", dbPort)
+        config.DBReplicaDataSourceName = fmt.Sprintf("postgres://%s:%s@%s:%s/%s?%s",
+            dbUser, dbPassword, replicaHost, replicaPort, dbName, dsnParams)
+    }
+    config.MigrationsDir = src.getEnvOrDefault("MIGRATIONS_DIR", "")
+
+    redisHost := src.getEnvOrDefault("NOTBACK_REDIS_HOST", "localhost")
+    redisPort := src.getEnvOrDefault("NOTBACK_REDIS_PORT", "6379")
     config.RedisAddr = fmt.Sprintf("%s:%s", redisHost, redisPort)
-    config.RedisPassword = os.Getenv("NOTBACK_REDIS_PASSWORD")
+    config.RedisPassword = src.getEnvOrDefault("NOTBACK_REDIS_PASSWORD", "")
     config.RedisURL = fmt.Sprintf("redis://%s", config.RedisAddr)
+    config.RedisKeyPrefix = src.getEnvOrDefault("REDIS_KEY_PREFIX", "")
+
+    config.RedisMode = src.getEnvOrDefault("REDIS_MODE", "standalone")
+    for _, addr := range strings.Split(src.getEnvOrDefault("REDIS_ADDRS", ""), ",") {
+        if addr = strings.TrimSpace(addr); addr != "" {
+            config.RedisAddrs = append(config.RedisAddrs, addr)
+        }
+    }
+    config.RedisMasterName = src.getEnvOrDefault("REDIS_MASTER_NAME", "")
+
+    if config.RedisMaxRetries, err = src.getIntEnvOrDefault("NOTBACK_REDIS_MAX_RETRIES", 3); err != nil {
+        return nil, err
+    }
+    if config.RedisDialTimeout, err = src.getDurationEnvOrDefault("NOTBACK_REDIS_DIAL_TIMEOUT", 5*time.Second); err != nil {
+        return nil, err
+    }
+    if config.RedisReadTimeout, err = src.getDurationEnvOrDefault("NOTBACK_REDIS_READ_TIMEOUT", 3*time.Second); err != nil {
+        return nil, err
+    }
+    if config.RedisHealthCheckInterval, err = src.getDurationEnvOrDefault("NOTBACK_REDIS_HEALTH_CHECK_INTERVAL", 5*time.Second); err != nil {
+        return nil, err
+    }
+    if config.RedisOpTimeout, err = src.getDurationEnvOrDefault("NOTBACK_REDIS_OP_TIMEOUT", 500*time.Millisecond); err != nil {
+        return nil, err
+    }
 
 	config.SaleCycleInterval = time.Hour
 	config.SaleDuration = time.Hour
 	config.CodeTTLExpiry = 5 * time.Minute
+
+    config.SaleTitle = src.getEnvOrDefault("SALE_TITLE", "")
+    config.SaleCategory = src.getEnvOrDefault("SALE_CATEGORY", "")
+
+    if config.SaleReaperInterval, err = src.getDurationEnvOrDefault("SALE_REAPER_INTERVAL", time.Minute); err != nil {
+        return nil, err
+    }
+    if config.ItemRetention, err = src.getDurationEnvOrDefault("ITEM_RETENTION", 0); err != nil {
+        return nil, err
+    }
+    if config.SoldReconcileInterval, err = src.getDurationEnvOrDefault("SOLD_RECONCILE_INTERVAL", 5*time.Minute); err != nil {
+        return nil, err
+    }
+    if config.LeaderboardRefreshInterval, err = src.getDurationEnvOrDefault("LEADERBOARD_REFRESH_INTERVAL", 5*time.Second); err != nil {
+        return nil, err
+    }
+    if config.SalePreviewLead, err = src.getDurationEnvOrDefault("SALE_PREVIEW_LEAD", 0); err != nil {
+        return nil, err
+    }
+    if config.SalePrewarmLead, err = src.getDurationEnvOrDefault("SALE_PREWARM_LEAD", 0); err != nil {
+        return nil, err
+    }
+    if config.ItemBatchRetries, err = src.getIntEnvOrDefault("ITEM_BATCH_RETRIES", 2); err != nil {
+        return nil, err
+    }
+    config.ImageBaseURL = src.getEnvOrDefault("IMAGE_BASE_URL", "https://example.com")
+    if config.ValidateImageURLs, err = src.getBoolEnvOrDefault("VALIDATE_IMAGE_URLS", true); err != nil {
+        return nil, err
+    }
+    if config.ItemsCacheTTL, err = src.getDurationEnvOrDefault("ITEMS_CACHE_TTL", time.Second); err != nil {
+        return nil, err
+    }
+    if config.MaxPageSize, err = src.getIntEnvOrDefault("MAX_PAGE_SIZE", 100); err != nil {
+        return nil, err
+    }
+    if config.RejectOversizedPage, err = src.getBoolEnvOrDefault("REJECT_OVERSIZED_PAGE", false); err != nil {
+        return nil, err
+    }
+    if config.ItemPriceCents, err = src.getIntEnvOrDefault("ITEM_PRICE_CENTS", 0); err != nil {
+        return nil, err
+    }
+    config.ItemCurrency = strings.ToUpper(src.getEnvOrDefault("ITEM_CURRENCY", "USD"))
+    if config.ItemSKUCount, err = src.getIntEnvOrDefault("ITEM_SKU_COUNT", 0); err != nil {
+        return nil, err
+    }
+    if config.MaxItemsPerSKU, err = src.getIntEnvOrDefault("MAX_ITEMS_PER_SKU", 0); err != nil {
+        return nil, err
+    }
+    if config.PaymentReferenceRequired, err = src.getBoolEnvOrDefault("PAYMENT_REFERENCE_REQUIRED", false); err != nil {
+        return nil, err
+    }
     config.ItemsPerSale = 10000
-    config.MaxItemsPerUser = 10
+    if config.MaxItemsPerUser, err = src.getIntEnvOrDefault("MAX_ITEMS_PER_USER", 10); err != nil {
+        return nil, err
+    }
+
+    if config.GlobalUserLimit, err = src.getIntEnvOrDefault("GLOBAL_USER_LIMIT", 0); err != nil {
+        return nil, err
+    }
+
+    if config.MaxActiveReservations, err = src.getIntEnvOrDefault("MAX_ACTIVE_RESERVATIONS", 0); err != nil {
+        return nil, err
+    }
+    if config.CheckoutQueueCapacity, err = src.getIntEnvOrDefault("CHECKOUT_QUEUE_CAPACITY", 0); err != nil {
+        return nil, err
+    }
+
+    if config.PurchaseVelocityLimit, err = src.getIntEnvOrDefault("PURCHASE_VELOCITY_LIMIT", 0); err != nil {
+        return nil, err
+    }
+    if config.PurchaseVelocityWindow, err = src.getDurationEnvOrDefault("PURCHASE_VELOCITY_WINDOW", time.Hour); err != nil {
+        return nil, err
+    }
+    if config.MinPurchaseDwell, err = src.getDurationEnvOrDefault("MIN_PURCHASE_DWELL", 0); err != nil {
+        return nil, err
+    }
+
+    if config.MysteryMode, err = src.getBoolEnvOrDefault("MYSTERY_MODE", false); err != nil {
+        return nil, err
+    }
+    if config.RejectOwnedItemCheckout, err = src.getBoolEnvOrDefault("REJECT_OWNED_ITEM_CHECKOUT", true); err != nil {
+        return nil, err
+    }
+    if config.RejectInactiveSaleItemCheckout, err = src.getBoolEnvOrDefault("REJECT_INACTIVE_SALE_ITEM_CHECKOUT", true); err != nil {
+        return nil, err
+    }
+
+    if config.SellThroughWarning, err = src.getFloatEnvOrDefault("SELL_THROUGH_WARNING", 0.9); err != nil {
+        return nil, err
+    }
+
+    if config.CheckoutCodeBytes, err = src.getIntEnvOrDefault("CHECKOUT_CODE_BYTES", 16); err != nil {
+        return nil, err
+    }
+    config.CheckoutCodeEncoding = src.getEnvOrDefault("CHECKOUT_CODE_ENCODING", "hex")
+    if config.ScarceSaleRemaining, err = src.getIntEnvOrDefault("SCARCE_SALE_REMAINING", 100); err != nil {
+        return nil, err
+    }
+    if config.PurchaseReplayTTL, err = src.getDurationEnvOrDefault("PURCHASE_REPLAY_TTL", 2*time.Minute); err != nil {
+        return nil, err
+    }
+
+    if config.PurchaseConcurrency, err = src.getIntEnvOrDefault("PURCHASE_CONCURRENCY", 0); err != nil {
+        return nil, err
+    }
+    if config.PurchaseQueueSize, err = src.getIntEnvOrDefault("PURCHASE_QUEUE_SIZE", 1000); err != nil {
+        return nil, err
+    }
+    if config.PurchaseQueueTimeout, err = src.getDurationEnvOrDefault("PURCHASE_QUEUE_TIMEOUT", 2*time.Second); err != nil {
+        return nil, err
+    }
+    config.PurchaseIsolation = src.getEnvOrDefault("PURCHASE_ISOLATION", "read_committed")
+
+    config.AdminToken = src.getEnvOrDefault("NOTBACK_ADMIN_TOKEN", "")
+    config.AnonymizeSecret = src.getEnvOrDefault("ANONYMIZE_SECRET", "")
+    config.OTLPEndpoint = src.getEnvOrDefault("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+
+    if config.FunnelTracking, err = src.getBoolEnvOrDefault("FUNNEL_TRACKING", false); err != nil {
+        return nil, err
+    }
+
+    if config.GzipMinSize, err = src.getIntEnvOrDefault("GZIP_MIN_SIZE", 1024); err != nil {
+        return nil, err
+    }
+
+    if config.WarmupConnections, err = src.getIntEnvOrDefault("WARMUP_CONNECTIONS", 10); err != nil {
+        return nil, err
+    }
+
+    for _, path := range strings.Split(src.getEnvOrDefault("ACCESS_LOG_EXCLUDE_PATHS", "/healthz,/readyz,/metrics"), ",") {
+        if path = strings.TrimSpace(path); path != "" {
+            config.AccessLogExcludePaths = append(config.AccessLogExcludePaths, path)
+        }
+    }
+
+    trustedProxies, err := parseTrustedProxies(src.getEnvOrDefault("TRUSTED_PROXIES", ""))
+    if err != nil {
+        return nil, err
+    }
+    config.TrustedProxies = trustedProxies
+
+    if err := src.checkUnusedFileKeys(); err != nil {
+        return nil, err
+    }
+    if err := config.Validate(); err != nil {
+        return nil, err
+    }
 
     return config, nil
 }
 
-func getEnvOrDefault(key, defaultValue string) string {
+// TLSEnabled reports whether the server should terminate TLS itself.
+func (c *Config) TLSEnabled() bool {
+    return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// Validate checks the merged configuration for values that are out of range
+// or inconsistent with each other.
+func (c *Config) Validate() error {
+    if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+        return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+    }
+    if c.DBStatementTimeout < 0 {
+        return fmt.Errorf("NOTBACK_DB_STATEMENT_TIMEOUT must not be negative")
+    }
+    if c.MaxItemsPerUser <= 0 {
+        return fmt.Errorf("MAX_ITEMS_PER_USER must be positive")
+    }
+    switch c.RedisMode {
+    case "standalone":
+    case "sentinel":
+        if len(c.RedisAddrs) == 0 || c.RedisMasterName == "" {
+            return fmt.Errorf("REDIS_MODE=sentinel requires REDIS_ADDRS and REDIS_MASTER_NAME")
+        }
+    case "cluster":
+        if len(c.RedisAddrs) == 0 {
+            return fmt.Errorf("REDIS_MODE=cluster requires REDIS_ADDRS")
+        }
+    default:
+        return fmt.Errorf("REDIS_MODE must be standalone, sentinel or cluster")
+    }
+    switch c.PurchaseIsolation {
+    case "read_committed", "repeatable_read", "serializable":
+    default:
+        return fmt.Errorf("PURCHASE_ISOLATION must be read_committed, repeatable_read or serializable")
+    }
+    if c.RedisHealthCheckInterval <= 0 {
+        return fmt.Errorf("NOTBACK_REDIS_HEALTH_CHECK_INTERVAL must be a positive duration")
+    }
+    if c.RedisOpTimeout < 0 {
+        return fmt.Errorf("NOTBACK_REDIS_OP_TIMEOUT must not be negative")
+    }
+    if c.SaleReaperInterval <= 0 {
+        return fmt.Errorf("SALE_REAPER_INTERVAL must be a positive duration")
+    }
+    if c.ItemRetention < 0 {
+        return fmt.Errorf("ITEM_RETENTION must not be negative")
+    }
+    if c.SoldReconcileInterval < 0 {
+        return fmt.Errorf("SOLD_RECONCILE_INTERVAL must not be negative")
+    }
+    if c.LeaderboardRefreshInterval < 0 {
+        return fmt.Errorf("LEADERBOARD_REFRESH_INTERVAL must not be negative")
+    }
+    if c.SalePreviewLead < 0 || c.SalePreviewLead >= c.SaleDuration {
+        return fmt.Errorf("SALE_PREVIEW_LEAD must be between 0 and the sale duration (%s)", c.SaleDuration)
+    }
+    if c.SalePrewarmLead < 0 || c.SalePrewarmLead >= c.SaleCycleInterval {
+        return fmt.Errorf("SALE_PREWARM_LEAD must be between 0 and the sale cycle interval (%s)", c.SaleCycleInterval)
+    }
+    if c.SellThroughWarning < 0 || c.SellThroughWarning > 1 {
+        return fmt.Errorf("SELL_THROUGH_WARNING must be between 0 and 1")
+    }
+    if c.ScarceSaleRemaining < 0 {
+        return fmt.Errorf("SCARCE_SALE_REMAINING must not be negative")
+    }
+    if c.PurchaseReplayTTL < 0 {
+        return fmt.Errorf("PURCHASE_REPLAY_TTL must not be negative")
+    }
+    if c.GlobalUserLimit < 0 {
+        return fmt.Errorf("GLOBAL_USER_LIMIT must not be negative")
+    }
+    if c.MaxActiveReservations < 0 {
+        return fmt.Errorf("MAX_ACTIVE_RESERVATIONS must not be negative")
+    }
+    if c.WarmupConnections < 0 {
+        return fmt.Errorf("WARMUP_CONNECTIONS must not be negative")
+    }
+    if c.ValidateImageURLs && c.ImageBaseURL != "" {
+        base, err := url.Parse(c.ImageBaseURL)
+        if err != nil || (base.Scheme != "http" && base.Scheme != "https") || base.Host == "" {
+            return fmt.Errorf("IMAGE_BASE_URL must be an http or https URL with a host")
+        }
+    }
+    if c.CheckoutQueueCapacity < 0 {
+        return fmt.Errorf("CHECKOUT_QUEUE_CAPACITY must not be negative")
+    }
+    if c.MinPurchaseDwell < 0 {
+        return fmt.Errorf("MIN_PURCHASE_DWELL must not be negative")
+    }
+    if c.PurchaseVelocityLimit < 0 {
+        return fmt.Errorf("PURCHASE_VELOCITY_LIMIT must not be negative")
+    }
+    if c.PurchaseVelocityLimit > 0 && c.PurchaseVelocityWindow <= 0 {
+        return fmt.Errorf("PURCHASE_VELOCITY_WINDOW must be a positive duration")
+    }
+    if c.MaxPageSize < 1 {
+        return fmt.Errorf("MAX_PAGE_SIZE must be at least 1")
+    }
+    if c.ItemsCacheTTL < 0 {
+        return fmt.Errorf("ITEMS_CACHE_TTL must not be negative")
+    }
+    if c.ItemBatchRetries < 0 {
+        return fmt.Errorf("ITEM_BATCH_RETRIES must not be negative")
+    }
+    if c.ItemSKUCount < 0 {
+        return fmt.Errorf("ITEM_SKU_COUNT must not be negative")
+    }
+    if c.MaxItemsPerSKU < 0 {
+        return fmt.Errorf("MAX_ITEMS_PER_SKU must not be negative")
+    }
+    if c.ItemPriceCents < 0 {
+        return fmt.Errorf("ITEM_PRICE_CENTS must not be negative")
+    }
+    if _, err := currency.ParseISO(c.ItemCurrency); err != nil {
+        return fmt.Errorf("ITEM_CURRENCY %q is not a known ISO 4217 currency code", c.ItemCurrency)
+    }
+    if c.PurchaseConcurrency < 0 || c.PurchaseQueueSize < 0 {
+        return fmt.Errorf("PURCHASE_CONCURRENCY and PURCHASE_QUEUE_SIZE must not be negative")
+    }
+    if c.PurchaseConcurrency > 0 && c.PurchaseQueueTimeout <= 0 {
+        return fmt.Errorf("PURCHASE_QUEUE_TIMEOUT must be a positive duration")
+    }
+    if c.CheckoutCodeBytes < minCheckoutCodeBytes {
+        return fmt.Errorf("CHECKOUT_CODE_BYTES must be at least %d", minCheckoutCodeBytes)
+    }
+    switch c.CheckoutCodeEncoding {
+    case "hex", "base64url", "base58":
+    default:
+        return fmt.Errorf("CHECKOUT_CODE_ENCODING must be hex, base64url or base58")
+    }
+    return nil
+}
+
+// parseTrustedProxies parses a comma-separated list of IPs and CIDRs.
+// Bare IPs are treated as single-address prefixes.
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+    var prefixes []netip.Prefix
+    for _, entry := range strings.Split(value, ",") {
+        entry = strings.TrimSpace(entry)
+        if entry == "" {
+            continue
+        }
+        if strings.Contains(entry, "/") {
+            prefix, err := netip.ParsePrefix(entry)
+            if err != nil {
+                return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", entry, err)
+            }
+            prefixes = append(prefixes, prefix.Masked())
+            continue
+        }
+        addr, err := netip.ParseAddr(entry)
+        if err != nil {
+            return nil, fmt.Errorf("invalid TRUSTED_PROXIES entry %q: %w", entry, err)
+        }
+        prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+    }
+    return prefixes, nil
+}
+
+// envSource resolves configuration keys from the environment first and then
+// from the optional config file, whose keys are the environment variable names.
+type envSource struct {
+    path string
+    file map[string]string
+    used map[string]bool
+}
+
+func newEnvSource(path string) (*envSource, error) {
+    src := &envSource{path: path, file: map[string]string{}, used: map[string]bool{}}
+    if path == "" {
+        return src, nil
+    }
+
+    content, err := os.ReadFile(path)
+    if err != nil {
+        return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+    }
+
+    var raw map[string]any
+    if err := json.Unmarshal(content, &raw); err != nil {
+        return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+    }
+    for key, value := range raw {
+        switch v := value.(type) {
+        case string:
+            src.file[key] = v
+        case float64:
+            src.file[key] = strconv.FormatFloat(v, 'f', -1, 64)
+        case bool:
+            src.file[key] = strconv.FormatBool(v)
+        case []any:
+            parts := make([]string, 0, len(v))
+            for _, elem := range v {
+                str, ok := elem.(string)
+                if !ok {
+                    return nil, fmt.Errorf("config file %s: %s must be a list of strings", path, key)
+                }
+                parts = append(parts, str)
+            }
+            src.file[key] = strings.Join(parts, ",")
+        default:
+            return nil, fmt.Errorf("config file %s: %s must be a string, number, boolean, or list of strings", path, key)
+        }
+    }
+    return src, nil
+}
+
+func (src *envSource) lookup(key string) string {
+    src.used[key] = true
     if value := os.Getenv(key); value != "" {
         return value
     }
+    return src.file[key]
+}
+
+// checkUnusedFileKeys rejects config file keys that no setting reads, which
+// are almost always typos.
+func (src *envSource) checkUnusedFileKeys() error {
+    var unknown []string
+    for key := range src.file {
+        if !src.used[key] {
+            unknown = append(unknown, key)
+        }
+    }
+    if len(unknown) == 0 {
+        return nil
+    }
+    sort.Strings(unknown)
+    return fmt.Errorf("config file %s: unknown keys %s", src.path, strings.Join(unknown, ", "))
+}
+
+func (src *envSource) getEnvOrDefault(key, defaultValue string) string {
+    if value := src.lookup(key); value != "" {
+        return value
+    }
     return defaultValue
 }
+
+func (src *envSource) getIntEnvOrDefault(key string, defaultValue int) (int, error) {
+    value := src.lookup(key)
+    if value == "" {
+        return defaultValue, nil
+    }
+    i, err := strconv.Atoi(value)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+    }
+    return i, nil
+}
+
+func (src *envSource) getDurationEnvOrDefault(key string, defaultValue time.Duration) (time.Duration, error) {
+    value := src.lookup(key)
+    if value == "" {
+        return defaultValue, nil
+    }
+    d, err := time.ParseDuration(value)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+    }
+    return d, nil
+}
+
+func (src *envSource) getBoolEnvOrDefault(key string, defaultValue bool) (bool, error) {
+    value := src.lookup(key)
+    if value == "" {
+        return defaultValue, nil
+    }
+    b, err := strconv.ParseBool(value)
+    if err != nil {
+        return false, fmt.Errorf("invalid %s %q: %w", key, value, err)
+    }
+    return b, nil
+}
+
+func (src *envSource) getFloatEnvOrDefault(key string, defaultValue float64) (float64, error) {
+    value := src.lookup(key)
+    if value == "" {
+        return defaultValue, nil
+    }
+    f, err := strconv.ParseFloat(value, 64)
+    if err != nil {
+        return 0, fmt.Errorf("invalid %s %q: %w", key, value, err)
+    }
+    return f, nil
+}
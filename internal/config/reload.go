@@ -0,0 +1,51 @@
+package config
+
+import (
+    "reflect"
+    "sort"
+)
+
+// reloadableFields are the Config fields that may change while the service
+// runs. Everything else (ports, DSNs, intervals, pool sizes) is read once at
+// startup and only changes with a restart.
+var reloadableFields = map[string]bool{
+    "MaxItemsPerUser":                true,
+    "GlobalUserLimit":                true,
+    "MaxItemsPerSKU":                 true,
+    "MaxActiveReservations":          true,
+    "CheckoutQueueCapacity":          true,
+    "PurchaseVelocityLimit":          true,
+    "PurchaseVelocityWindow":         true,
+    "MinPurchaseDwell":               true,
+    "ItemsCacheTTL":                  true,
+    "PurchaseReplayTTL":              true,
+    "ScarceSaleRemaining":            true,
+    "SellThroughWarning":             true,
+    "RejectOwnedItemCheckout":        true,
+    "RejectInactiveSaleItemCheckout": true,
+    "FunnelTracking":                 true,
+}
+
+// Reload returns a copy of c with the reloadable fields taken from next,
+// along with the names of the reloadable fields that changed and of the
+// other fields that differ, which are left as they were.
+func (c *Config) Reload(next *Config) (reloaded *Config, applied, ignored []string) {
+    copied := *c
+    dst := reflect.ValueOf(&copied).Elem()
+    src := reflect.ValueOf(next).Elem()
+    for i := 0; i < dst.NumField(); i++ {
+        name := dst.Type().Field(i).Name
+        if reflect.DeepEqual(dst.Field(i).Interface(), src.Field(i).Interface()) {
+            continue
+        }
+        if !reloadableFields[name] {
+            ignored = append(ignored, name)
+            continue
+        }
+        dst.Field(i).Set(src.Field(i))
+        applied = append(applied, name)
+    }
+    sort.Strings(applied)
+    sort.Strings(ignored)
+    return &copied, applied, ignored
+}
@@ -0,0 +1,376 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+const defaultAdminListLimit = 100
+
+// RequireAdmin rejects requests that do not carry the configured admin token
+// in the X-Admin-Token header. An empty token disables the admin API.
+func RequireAdmin(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeJSONError(w, http.StatusForbidden, "admin API is disabled")
+			return
+		}
+		provided := r.Header.Get("X-Admin-Token")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			writeJSONError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type AdminHandler struct {
+	logger         *log.Logger
+	saleService    *service.SaleService
+	trustedProxies []netip.Prefix
+}
+
+func NewAdminHandler(logger *log.Logger, saleService *service.SaleService, trustedProxies []netip.Prefix) *AdminHandler {
+	return &AdminHandler{
+		logger:         logger,
+		saleService:    saleService,
+		trustedProxies: trustedProxies,
+	}
+}
+
+type CheckoutsByIPResponsePayload struct {
+	SaleID int64                    `json:"sale_id"`
+	Counts []models.IPCheckoutCount `json:"counts"`
+}
+
+// CheckoutsByIP serves GET /admin/sales/{id}/checkouts-by-ip.
+func (h *AdminHandler) CheckoutsByIP(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	limit, ok := adminListLimit(w, r)
+	if !ok {
+		return
+	}
+
+	counts, err := h.saleService.CheckoutCountsByIP(r.Context(), saleID, limit)
+	if err != nil {
+		h.logger.Printf("Error counting checkouts by IP for sale %d: %v", saleID, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+
+	resp := CheckoutsByIPResponsePayload{SaleID: saleID, Counts: counts}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding checkouts by IP response: %v", err)
+	}
+}
+
+type SaleItemsResponsePayload struct {
+	SaleID int64         `json:"sale_id"`
+	Sold   *bool         `json:"sold,omitempty"`
+	Items  []models.Item `json:"items"`
+	Limit  int           `json:"limit"`
+	Offset int           `json:"offset"`
+}
+
+// SaleItems serves GET /admin/sales/{id}/items. The optional sold parameter
+// narrows the page to sold (true) or unsold (false) items.
+func (h *AdminHandler) SaleItems(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	limit, ok := adminListLimit(w, r)
+	if !ok {
+		return
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	var sold *bool
+	if soldStr := r.URL.Query().Get("sold"); soldStr != "" {
+		value, err := strconv.ParseBool(soldStr)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "sold must be true or false")
+			return
+		}
+		sold = &value
+	}
+
+	items, err := h.saleService.GetItemsForSale(r.Context(), saleID, sold, limit, offset)
+	if err != nil {
+		switch err {
+		case service.ErrSaleNotFound:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error listing items of sale %d: %v", saleID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	resp := SaleItemsResponsePayload{SaleID: saleID, Sold: sold, Items: items, Limit: limit, Offset: offset}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding sale items response: %v", err)
+	}
+}
+
+// SaleStats serves GET /admin/sales/{id}/stats.
+func (h *AdminHandler) SaleStats(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	stats, err := h.saleService.GetSaleStats(r.Context(), saleID)
+	if err != nil {
+		switch err {
+		case service.ErrSaleNotFound:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error getting stats for sale %d: %v", saleID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, stats); err != nil {
+		h.logger.Printf("Error encoding sale stats response: %v", err)
+	}
+}
+
+type ReimageRequestPayload struct {
+	Template string `json:"template"`
+}
+
+func (p *ReimageRequestPayload) validate() []FieldError {
+	if p.Template == "" {
+		return []FieldError{{Field: "template", Message: "required"}}
+	}
+	rest := p.Template
+	for _, placeholder := range service.ImageTemplatePlaceholders {
+		rest = strings.ReplaceAll(rest, placeholder, "")
+	}
+	if strings.ContainsAny(rest, "{}") {
+		return []FieldError{{Field: "template", Message: "unknown placeholder; use " + strings.Join(service.ImageTemplatePlaceholders, ", ")}}
+	}
+	return nil
+}
+
+type ReimageResponsePayload struct {
+	SaleID  int64 `json:"sale_id"`
+	Updated int64 `json:"updated"`
+}
+
+// Reimage serves POST /admin/sales/{id}/reimage.
+func (h *AdminHandler) Reimage(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	var req ReimageRequestPayload
+	if errs := decodeJSONBody(w, r, &req); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if errs := req.validate(); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	updated, err := h.saleService.ReimageSale(r.Context(), saleID, req.Template)
+	if err != nil {
+		var invalid *service.InvalidImageURLError
+		if errors.As(err, &invalid) {
+			writeValidationErrors(w, []FieldError{{Field: "template", Message: "image URL " + invalid.Reason}})
+			return
+		}
+
+		switch err {
+		case service.ErrSaleNotFound:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error reimaging sale %d: %v", saleID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, ReimageResponsePayload{SaleID: saleID, Updated: updated}); err != nil {
+		h.logger.Printf("Error encoding reimage response: %v", err)
+	}
+}
+
+type DeactivateAllResponsePayload struct {
+	Deactivated int     `json:"deactivated"`
+	SaleIDs     []int64 `json:"sale_ids"`
+}
+
+// DeactivateAll serves POST /admin/sales/deactivate-all.
+func (h *AdminHandler) DeactivateAll(w http.ResponseWriter, r *http.Request) {
+	h.logger.Printf("ALERT: deactivation of all sales requested by %s (%q)", clientIP(r, h.trustedProxies), userAgent(r))
+
+	saleIDs, err := h.saleService.DeactivateAllSales(r.Context())
+	if err != nil {
+		h.logger.Printf("Error deactivating all sales: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+	h.logger.Printf("Deactivated %d sales on admin request: %v", len(saleIDs), saleIDs)
+
+	if saleIDs == nil {
+		saleIDs = []int64{}
+	}
+	resp := DeactivateAllResponsePayload{Deactivated: len(saleIDs), SaleIDs: saleIDs}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding deactivate-all response: %v", err)
+	}
+}
+
+// PurgeSale serves DELETE /admin/sales/{id}.
+func (h *AdminHandler) PurgeSale(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	purge, err := h.saleService.PurgeSale(r.Context(), saleID)
+	if err != nil {
+		switch err {
+		case service.ErrSaleNotFound:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		case service.ErrSaleNotFinished:
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			h.logger.Printf("Error purging sale %d: %v", saleID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, purge); err != nil {
+		h.logger.Printf("Error encoding purge response: %v", err)
+	}
+}
+
+// DBStats serves GET /admin/db-stats.
+func (h *AdminHandler) DBStats(w http.ResponseWriter, r *http.Request) {
+	if err := writeJSON(w, http.StatusOK, h.saleService.PoolStats()); err != nil {
+		h.logger.Printf("Error encoding pool stats response: %v", err)
+	}
+}
+
+// DisableItem serves POST /admin/items/{itemID}/disable.
+func (h *AdminHandler) DisableItem(w http.ResponseWriter, r *http.Request) {
+	itemID, err := strconv.ParseInt(r.PathValue("itemID"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid item id format")
+		return
+	}
+
+	if err := h.saleService.DisableItem(r.Context(), itemID); err != nil {
+		switch err {
+		case service.ErrItemNotFound:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error disabling item %d: %v", itemID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Funnel serves GET /admin/funnel?sale_id=X.
+func (h *AdminHandler) Funnel(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.URL.Query().Get("sale_id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "sale_id query parameter is required and must be an integer")
+		return
+	}
+
+	funnel, err := h.saleService.GetSaleFunnel(r.Context(), saleID)
+	if err != nil {
+		h.logger.Printf("Error getting funnel for sale %d: %v", saleID, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, funnel); err != nil {
+		h.logger.Printf("Error encoding funnel response: %v", err)
+	}
+}
+
+type ReleaseReservationsResponsePayload struct {
+	UserID   string `json:"user_id"`
+	SaleID   int64  `json:"sale_id"`
+	Released int    `json:"released"`
+}
+
+// ReleaseUserReservations serves POST /admin/users/{userID}/release.
+func (h *AdminHandler) ReleaseUserReservations(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	sale, released, err := h.saleService.ReleaseUserReservations(r.Context(), userID)
+	if err != nil {
+		switch err {
+		case service.ErrSaleNotActive:
+			writeJSONError(w, http.StatusConflict, err.Error())
+		default:
+			h.logger.Printf("Error releasing reservations for user %s: %v", userID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	resp := ReleaseReservationsResponsePayload{UserID: userID, SaleID: sale.ID, Released: released}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding release reservations response: %v", err)
+	}
+}
+
+// adminListLimit parses the limit of an admin list, capped at
+// MaxAdminItemsPage so no request can ask for an unbounded scan.
+func adminListLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	limit, ok := parseLimit(w, r, defaultAdminListLimit)
+	return min(limit, service.MaxAdminItemsPage), ok
+}
+
+func parseLimit(w http.ResponseWriter, r *http.Request, defaultLimit int) (int, bool) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultLimit, true
+	}
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return 0, false
+	}
+	return limit, true
+}
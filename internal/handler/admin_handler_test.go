@@ -0,0 +1,38 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"notcoin_contest/internal/service"
+)
+
+func TestAdminListLimit(t *testing.T) {
+	tests := []struct {
+		query      string
+		wantLimit  int
+		wantOK     bool
+		wantStatus int
+	}{
+		{"", defaultAdminListLimit, true, http.StatusOK},
+		{"?limit=20", 20, true, http.StatusOK},
+		{"?limit=1000000", service.MaxAdminItemsPage, true, http.StatusOK},
+		{"?limit=0", 0, false, http.StatusBadRequest},
+		{"?limit=abc", 0, false, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/admin/sales/1/checkouts-by-ip"+tt.query, nil)
+
+			limit, ok := adminListLimit(rec, r)
+			if ok != tt.wantOK || (ok && limit != tt.wantLimit) {
+				t.Fatalf("adminListLimit = %d, %v; want %d, %v", limit, ok, tt.wantLimit, tt.wantOK)
+			}
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -2,27 +2,66 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
+	"net/netip"
 	"strconv"
 
 	"notcoin_contest/internal/service"
 )
 
 type CheckoutHandler struct {
-	logger      *log.Logger
-	saleService *service.SaleService
+	logger         *log.Logger
+	saleService    *service.SaleService
+	trustedProxies []netip.Prefix
 }
 
-func NewCheckoutHandler(logger *log.Logger, saleService *service.SaleService) *CheckoutHandler {
+func NewCheckoutHandler(logger *log.Logger, saleService *service.SaleService, trustedProxies []netip.Prefix) *CheckoutHandler {
 	return &CheckoutHandler{
-		logger:      logger,
-		saleService: saleService,
+		logger:         logger,
+		saleService:    saleService,
+		trustedProxies: trustedProxies,
 	}
 }
 
+type CheckoutRequestPayload struct {
+	UserID string `json:"user_id"`
+	ItemID *int64 `json:"item_id"`
+	// ShortCode asks for a short alias of the code as well.
+	ShortCode bool `json:"short_code"`
+}
+
+// validate checks the payload. In mystery mode the server picks the item,
+// so item_id must be left out.
+func (p CheckoutRequestPayload) validate(mystery bool) []FieldError {
+	var errs []FieldError
+	if p.UserID == "" {
+		errs = append(errs, FieldError{Field: "user_id", Message: "required"})
+	}
+	if p.ItemID == nil && !mystery {
+		errs = append(errs, FieldError{Field: "item_id", Message: "required"})
+	}
+	if p.ItemID != nil && mystery {
+		errs = append(errs, FieldError{Field: "item_id", Message: "not allowed in mystery mode"})
+	}
+	return errs
+}
+
 type CheckoutResponsePayload struct {
 	Code string `json:"code"`
+	// ShortCode is the alias of Code, when one was asked for.
+	ShortCode string `json:"short_code,omitempty"`
+	// ItemID is the item assigned in mystery mode.
+	ItemID int64 `json:"item_id,omitempty"`
+}
+
+// CheckoutQueuedPayload answers a checkout made while the sale is at
+// capacity. The client retries after Retry-After to keep its place.
+type CheckoutQueuedPayload struct {
+	Queued               bool  `json:"queued"`
+	Position             int64 `json:"position"`
+	EstimatedWaitSeconds int64 `json:"estimated_wait_seconds"`
 }
 
 func (h *CheckoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -32,33 +71,117 @@ func (h *CheckoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	userID := r.URL.Query().Get("user_id")
-	itemIDStr := r.URL.Query().Get("id")
+	mystery := h.saleService.MysteryMode()
 
-	if userID == "" {
-		http.Error(w, "user_id query parameter is required", http.StatusBadRequest)
+	var userID string
+	var itemID int64
+	var shortCode bool
+	useBody, errs := hasJSONBody(r)
+	if errs != nil {
+		writeValidationErrors(w, errs)
 		return
 	}
-	if itemIDStr == "" {
-		http.Error(w, "id query parameter is required", http.StatusBadRequest)
-		return
+	if useBody {
+		var req CheckoutRequestPayload
+		if errs := decodeJSONBody(w, r, &req); errs != nil {
+			writeValidationErrors(w, errs)
+			return
+		}
+		if errs := req.validate(mystery); errs != nil {
+			writeValidationErrors(w, errs)
+			return
+		}
+		userID = req.UserID
+		setAccessLogUser(r.Context(), userID)
+		shortCode = req.ShortCode
+		if req.ItemID != nil {
+			itemID = *req.ItemID
+		}
+	} else {
+		userID = r.URL.Query().Get("user_id")
+		itemIDStr := r.URL.Query().Get("id")
+
+		if userID == "" {
+			http.Error(w, "user_id query parameter is required", http.StatusBadRequest)
+			return
+		}
+		if mystery && itemIDStr != "" {
+			http.Error(w, "id query parameter is not allowed in mystery mode", http.StatusBadRequest)
+			return
+		}
+		if !mystery && itemIDStr == "" {
+			http.Error(w, "id query parameter is required", http.StatusBadRequest)
+			return
+		}
+
+		if !mystery {
+			var err error
+			itemID, err = strconv.ParseInt(itemIDStr, 10, 64)
+			if err != nil {
+				http.Error(w, "Invalid item id format", http.StatusBadRequest)
+				return
+			}
+		}
+		if v := r.URL.Query().Get("short_code"); v != "" {
+			var err error
+			shortCode, err = strconv.ParseBool(v)
+			if err != nil {
+				http.Error(w, "short_code query parameter must be a boolean", http.StatusBadRequest)
+				return
+			}
+		}
 	}
 
-	itemID, err := strconv.ParseInt(itemIDStr, 10, 64)
-	if err != nil {
-		http.Error(w, "Invalid item id format", http.StatusBadRequest)
-		return
+	meta := service.CheckoutMeta{
+		ClientIP:  clientIP(r, h.trustedProxies),
+		UserAgent: userAgent(r),
 	}
 
-	code, err := h.saleService.ProcessCheckout(r.Context(), userID, itemID)
+	var result *service.CheckoutResult
+	var err error
+	if mystery {
+		result, err = h.saleService.ProcessMysteryCheckout(r.Context(), userID, meta)
+	} else {
+		result, err = h.saleService.ProcessCheckout(r.Context(), userID, itemID, meta)
+	}
 	if err != nil {
+		var notStarted *service.SaleNotStartedError
+		if errors.As(err, &notStarted) {
+			setRetryAfter(w, notStarted.OpensAt)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		var queued *service.CheckoutQueuedError
+		if errors.As(err, &queued) {
+			setRetryAfterQueued(w)
+			writeJSON(w, http.StatusAccepted, CheckoutQueuedPayload{
+				Queued:               true,
+				Position:             queued.Position,
+				EstimatedWaitSeconds: int64(queued.EstimatedWait.Seconds()),
+			})
+			return
+		}
+
 		switch err {
 		case service.ErrSaleNotActive:
+			setRetryAfterNextSale(w, h.saleService)
 			http.Error(w, err.Error(), http.StatusServiceUnavailable)
 		case service.ErrItemNotFoundOrSold:
 			http.Error(w, err.Error(), http.StatusNotFound)
-		case service.ErrUserLimitReached:
+		case service.ErrUserLimitReached, service.ErrGlobalUserLimitReached, service.ErrUserNotAllowed:
 			http.Error(w, err.Error(), http.StatusForbidden)
+		case service.ErrItemAlreadyPurchased, service.ErrItemFromInactiveSale:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case service.ErrSaleLimitReached:
+			http.Error(w, "sale sold out", http.StatusConflict)
+		case service.ErrTooManyReservations:
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+		case service.ErrUserListsUnavailable:
+			setRetryAfterDegraded(w)
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		case service.ErrDatabaseUnavailable:
+			setRetryAfterDegraded(w)
+			http.Error(w, "checkout is temporarily disabled: "+err.Error(), http.StatusServiceUnavailable)
 		case service.ErrCheckoutFailed:
 			http.Error(w, "Internal server error during checkout", http.StatusInternalServerError)
 		default:
@@ -67,7 +190,21 @@ func (h *CheckoutHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := CheckoutResponsePayload{Code: code}
+	resp := CheckoutResponsePayload{Code: result.Code}
+	if mystery {
+		resp.ItemID = result.ItemID
+	}
+	if shortCode {
+		// The code itself is issued and usable, so a failed alias only
+		// leaves short_code out of the response.
+		alias, err := h.saleService.CreateCheckoutAlias(r.Context(), result.Code)
+		if err != nil {
+			h.logger.Printf("Error creating alias for checkout code %s: %v", result.Code, err)
+		}
+		resp.ShortCode = alias
+	}
+	w.Header().Set("X-User-Limit", strconv.Itoa(result.UserLimit))
+	w.Header().Set("X-User-Remaining", strconv.Itoa(result.UserRemaining))
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	if err := json.NewEncoder(w).Encode(resp); err != nil {
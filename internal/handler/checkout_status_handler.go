@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"notcoin_contest/internal/service"
+)
+
+type CheckoutStatusHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewCheckoutStatusHandler(logger *log.Logger, saleService *service.SaleService) *CheckoutStatusHandler {
+	return &CheckoutStatusHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+func (h *CheckoutStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code := r.URL.Query().Get("code")
+	userID := r.URL.Query().Get("user_id")
+
+	var errs []FieldError
+	if code == "" {
+		errs = append(errs, FieldError{Field: "code", Message: "required"})
+	}
+	if userID == "" {
+		errs = append(errs, FieldError{Field: "user_id", Message: "required"})
+	}
+	if errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	status, err := h.saleService.CheckoutStatus(r.Context(), code, userID)
+	if err != nil {
+		switch err {
+		case service.ErrCheckoutCodeInvalid:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error getting checkout status: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, status); err != nil {
+		h.logger.Printf("Error encoding checkout status response: %v", err)
+	}
+}
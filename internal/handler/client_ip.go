@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const maxUserAgentLength = 512
+
+// clientIP returns the address of the client that issued the request. The
+// X-Forwarded-For header is only honored when the direct peer is one of the
+// trusted proxies; in that case the chain is walked from the right and the
+// first untrusted hop is taken as the client.
+func clientIP(r *http.Request, trustedProxies []netip.Prefix) string {
+	peer, ok := parseRemoteAddr(r.RemoteAddr)
+	if !ok {
+		return ""
+	}
+	if !isTrustedProxy(peer, trustedProxies) {
+		return peer.String()
+	}
+
+	forwarded := r.Header.Values("X-Forwarded-For")
+	hops := strings.Split(strings.Join(forwarded, ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		addr, err := netip.ParseAddr(hop)
+		if err != nil {
+			break
+		}
+		addr = addr.Unmap()
+		if !isTrustedProxy(addr, trustedProxies) {
+			return addr.String()
+		}
+	}
+	return peer.String()
+}
+
+func userAgent(r *http.Request) string {
+	ua := r.UserAgent()
+	if len(ua) > maxUserAgentLength {
+		ua = ua[:maxUserAgentLength]
+	}
+	return ua
+}
+
+func parseRemoteAddr(remoteAddr string) (netip.Addr, bool) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
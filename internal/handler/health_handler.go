@@ -0,0 +1,22 @@
+package handler
+
+import "net/http"
+
+// Healthz reports that the process is up and serving. It deliberately does
+// not depend on Postgres or Redis so it keeps answering during incidents.
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readyz answers 503 until ready reports true, so a load balancer holds
+// traffic back while the instance warms up. Like Healthz it does not probe
+// Postgres or Redis on each call.
+func Readyz(ready func() bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !ready() {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "warming up"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+	}
+}
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+const (
+	itemStatusAvailable = "available"
+	itemStatusSold      = "sold"
+	itemStatusDisabled  = "disabled"
+	itemStatusReserved  = "reserved"
+)
+
+type ItemHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewItemHandler(logger *log.Logger, saleService *service.SaleService) *ItemHandler {
+	return &ItemHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type ItemStatusResponsePayload struct {
+	models.Item
+	Status string `json:"status"`
+}
+
+func (h *ItemHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+	itemID, err := strconv.ParseInt(r.PathValue("itemID"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid item id format")
+		return
+	}
+
+	item, reserved, err := h.saleService.GetSaleItem(r.Context(), saleID, itemID)
+	if err != nil {
+		switch err {
+		case service.ErrItemNotFound:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error getting item %d of sale %d: %v", itemID, saleID, err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	resp := ItemStatusResponsePayload{Item: *item, Status: itemStatusAvailable}
+	switch {
+	case item.IsSold:
+		resp.Status = itemStatusSold
+	case item.IsDisabled:
+		resp.Status = itemStatusDisabled
+	case reserved:
+		resp.Status = itemStatusReserved
+	}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding item response: %v", err)
+	}
+}
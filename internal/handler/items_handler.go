@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+const defaultItemsPageSize = 50
+
+type ItemsHandler struct {
+	logger              *log.Logger
+	saleService         *service.SaleService
+	maxPageSize         int
+	rejectOversizedPage bool
+}
+
+// NewItemsHandler builds the /items handler. Limits above maxPageSize are
+// clamped, or refused with 400 when rejectOversizedPage is set.
+func NewItemsHandler(logger *log.Logger, saleService *service.SaleService, maxPageSize int, rejectOversizedPage bool) *ItemsHandler {
+	return &ItemsHandler{
+		logger:              logger,
+		saleService:         saleService,
+		maxPageSize:         maxPageSize,
+		rejectOversizedPage: rejectOversizedPage,
+	}
+}
+
+type ItemsResponsePayload struct {
+	SaleID          int64     `json:"sale_id"`
+	SaleTitle       string    `json:"sale_title,omitempty"`
+	PurchaseOpensAt time.Time `json:"purchase_opens_at"`
+	// Items holds []models.Item, or one map per item with only the fields
+	// asked for.
+	Items any `json:"items"`
+	Limit int `json:"limit"`
+	// LimitClamped is set when the requested limit exceeded the maximum
+	// page size and Limit was lowered to it.
+	LimitClamped bool `json:"limit_clamped,omitempty"`
+	Offset       int  `json:"offset"`
+	// NextCursor is the cursor for the following page, absent on the last
+	// and when sorting by anything but ID.
+	NextCursor *int64 `json:"next_cursor,omitempty"`
+}
+
+// itemFields maps each item field a client may select with fields= to its
+// value.
+var itemFields = map[string]func(models.Item) any{
+	"id":          func(i models.Item) any { return i.ID },
+	"sale_id":     func(i models.Item) any { return i.SaleID },
+	"name":        func(i models.Item) any { return i.Name },
+	"image_url":   func(i models.Item) any { return i.ImageURL },
+	"price_cents": func(i models.Item) any { return i.PriceCents },
+	"currency":    func(i models.Item) any { return i.Currency },
+	"sku":         func(i models.Item) any { return i.SKU },
+	"is_sold":     func(i models.Item) any { return i.IsSold },
+	"is_disabled": func(i models.Item) any { return i.IsDisabled },
+	"created_at":  func(i models.Item) any { return i.CreatedAt },
+	"updated_at":  func(i models.Item) any { return i.UpdatedAt },
+}
+
+// parseItemFields reads the comma-separated fields query parameter. It
+// returns nil when all fields are wanted and writes a 400 for unknown ones.
+func parseItemFields(w http.ResponseWriter, r *http.Request) ([]string, bool) {
+	value := r.URL.Query().Get("fields")
+	if value == "" {
+		return nil, true
+	}
+	var fields []string
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if _, ok := itemFields[field]; !ok {
+			writeJSONError(w, http.StatusBadRequest, "unknown field "+strconv.Quote(field))
+			return nil, false
+		}
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "fields must name at least one field")
+		return nil, false
+	}
+	return fields, true
+}
+
+// selectItemFields renders each item as a map holding only fields.
+func selectItemFields(items []models.Item, fields []string) []map[string]any {
+	selected := make([]map[string]any, len(items))
+	for i, item := range items {
+		m := make(map[string]any, len(fields))
+		for _, field := range fields {
+			m[field] = itemFields[field](item)
+		}
+		selected[i] = m
+	}
+	return selected
+}
+
+func (h *ItemsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		h.logger.Printf("Method not allowed for /items: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit, ok := parseLimit(w, r, min(defaultItemsPageSize, h.maxPageSize))
+	if !ok {
+		return
+	}
+	clamped := false
+	if limit > h.maxPageSize {
+		if h.rejectOversizedPage {
+			writeJSONError(w, http.StatusBadRequest, "limit must be at most "+strconv.Itoa(h.maxPageSize))
+			return
+		}
+		limit = h.maxPageSize
+		clamped = true
+	}
+
+	offset := 0
+	if offsetStr := r.URL.Query().Get("offset"); offsetStr != "" {
+		var err error
+		offset, err = strconv.Atoi(offsetStr)
+		if err != nil || offset < 0 {
+			writeJSONError(w, http.StatusBadRequest, "offset must be a non-negative integer")
+			return
+		}
+	}
+
+	var cursor int64
+	if cursorStr := r.URL.Query().Get("cursor"); cursorStr != "" {
+		var err error
+		cursor, err = strconv.ParseInt(cursorStr, 10, 64)
+		if err != nil || cursor < 0 {
+			writeJSONError(w, http.StatusBadRequest, "cursor must be a non-negative integer")
+			return
+		}
+		if offset != 0 {
+			writeJSONError(w, http.StatusBadRequest, "cursor and offset cannot be combined")
+			return
+		}
+	}
+
+	// Cursors are item IDs, so they only page through ID order, and a
+	// random order has no stable pages to offset into.
+	sort := r.URL.Query().Get("sort")
+	if cursor != 0 && sort != "" && sort != service.ItemSortID {
+		writeJSONError(w, http.StatusBadRequest, "cursor can only be used with sort=id")
+		return
+	}
+	if offset != 0 && sort == service.ItemSortRandom {
+		writeJSONError(w, http.StatusBadRequest, "offset cannot be used with sort=random")
+		return
+	}
+
+	fields, ok := parseItemFields(w, r)
+	if !ok {
+		return
+	}
+
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	sale, items, err := h.saleService.ListItems(r.Context(), query, sort, cursor, limit, offset)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidItemSort:
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		case service.ErrSaleNotActive:
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		case service.ErrDatabaseUnavailable:
+			setRetryAfterDegraded(w)
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+		default:
+			h.logger.Printf("Error listing items: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	resp := ItemsResponsePayload{
+		SaleID:          sale.ID,
+		SaleTitle:       sale.Title,
+		PurchaseOpensAt: sale.StartTime,
+		Items:           items,
+		Limit:           limit,
+		LimitClamped:    clamped,
+		Offset:          offset,
+	}
+	if fields != nil {
+		resp.Items = selectItemFields(items, fields)
+	}
+	if len(items) == limit && (sort == "" || sort == service.ItemSortID) {
+		next := items[len(items)-1].ID
+		resp.NextCursor = &next
+	}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding items response: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+type LeaderboardHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewLeaderboardHandler(logger *log.Logger, saleService *service.SaleService) *LeaderboardHandler {
+	return &LeaderboardHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type LeaderboardResponsePayload struct {
+	SaleID  int64                     `json:"sale_id"`
+	Entries []models.LeaderboardEntry `json:"entries"`
+}
+
+// ServeHTTP serves GET /sales/{id}/leaderboard.
+func (h *LeaderboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	entries, err := h.saleService.GetLeaderboard(r.Context(), saleID)
+	if err != nil {
+		h.logger.Printf("Error getting leaderboard for sale %d: %v", saleID, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+
+	resp := LeaderboardResponsePayload{SaleID: saleID, Entries: entries}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding leaderboard response: %v", err)
+	}
+}
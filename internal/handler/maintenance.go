@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"notcoin_contest/internal/service"
+)
+
+// Maintenance answers 503 while maintenance mode is on. If the flag cannot
+// be read, requests are let through so a Redis hiccup does not halt sales.
+func Maintenance(logger *log.Logger, saleService *service.SaleService, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status, err := saleService.MaintenanceStatus(r.Context())
+		if err != nil {
+			logger.Printf("Error reading maintenance flag, allowing request: %v", err)
+		} else if status.Enabled {
+			writeJSONError(w, http.StatusServiceUnavailable, status.Message)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type MaintenanceRequestPayload struct {
+	Enabled *bool  `json:"enabled"`
+	Message string `json:"message"`
+}
+
+// GetMaintenance serves GET /admin/maintenance.
+func (h *AdminHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	status, err := h.saleService.MaintenanceStatus(r.Context())
+	if err != nil {
+		h.logger.Printf("Error reading maintenance flag: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, status); err != nil {
+		h.logger.Printf("Error encoding maintenance response: %v", err)
+	}
+}
+
+// SetMaintenance serves PUT /admin/maintenance.
+func (h *AdminHandler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var payload MaintenanceRequestPayload
+	if errs := decodeJSONBody(w, r, &payload); len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if payload.Enabled == nil {
+		writeValidationErrors(w, []FieldError{{Field: "enabled", Message: "required"}})
+		return
+	}
+
+	if err := h.saleService.SetMaintenance(r.Context(), *payload.Enabled, payload.Message); err != nil {
+		h.logger.Printf("Error updating maintenance flag: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+	h.GetMaintenance(w, r)
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"notcoin_contest/internal/service"
+)
+
+const maxPurchaseBatchSize = 20
+
+type PurchaseBatchHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewPurchaseBatchHandler(logger *log.Logger, saleService *service.SaleService) *PurchaseBatchHandler {
+	return &PurchaseBatchHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type PurchaseBatchRequestPayload struct {
+	Codes []string `json:"codes"`
+}
+
+type PurchaseBatchResultPayload struct {
+	Code string `json:"code"`
+	PurchaseResponsePayload
+}
+
+type PurchaseBatchResponsePayload struct {
+	Succeeded int                          `json:"succeeded"`
+	Failed    int                          `json:"failed"`
+	Results   []PurchaseBatchResultPayload `json:"results"`
+}
+
+func (h *PurchaseBatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var req PurchaseBatchRequestPayload
+	if errs := decodeJSONBody(w, r, &req); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if errs := req.validate(); errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	results, err := h.saleService.ProcessPurchaseBatch(r.Context(), req.Codes)
+	if errors.Is(err, service.ErrPurchaseBatchMixedUsers) {
+		writeValidationErrors(w, []FieldError{{Field: "codes", Message: err.Error()}})
+		return
+	}
+	if err != nil {
+		h.logger.Printf("Error processing batch purchase: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred during purchase")
+		return
+	}
+
+	resp := PurchaseBatchResponsePayload{Results: make([]PurchaseBatchResultPayload, 0, len(results))}
+	for _, result := range results {
+		payload := PurchaseBatchResultPayload{Code: result.Code}
+		if result.Err != nil {
+			_, message := purchaseErrorStatus(result.Err)
+			payload.Status = "failed"
+			payload.Message = message
+			resp.Failed++
+		} else {
+			payload.Status = "success"
+			payload.Message = "Item purchased successfully"
+			payload.ItemID = result.Item.ID
+			payload.PriceCents = result.Item.PriceCents
+			payload.Currency = result.Item.Currency
+			resp.Succeeded++
+		}
+		resp.Results = append(resp.Results, payload)
+	}
+
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding batch purchase response: %v", err)
+	}
+}
+
+func (p PurchaseBatchRequestPayload) validate() []FieldError {
+	var errs []FieldError
+	if len(p.Codes) == 0 {
+		errs = append(errs, FieldError{Field: "codes", Message: "required"})
+	}
+	if len(p.Codes) > maxPurchaseBatchSize {
+		errs = append(errs, FieldError{Field: "codes", Message: fmt.Sprintf("must contain at most %d codes", maxPurchaseBatchSize)})
+	}
+	for i, code := range p.Codes {
+		if code == "" {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("codes[%d]", i), Message: "required"})
+		}
+	}
+	return errs
+}
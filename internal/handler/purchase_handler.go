@@ -2,6 +2,7 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"net/http"
 
@@ -20,10 +21,17 @@ func NewPurchaseHandler(logger *log.Logger, saleService *service.SaleService) *P
 	}
 }
 
+type PurchaseRequestPayload struct {
+	Code             string `json:"code"`
+	PaymentReference string `json:"payment_reference"`
+}
+
 type PurchaseResponsePayload struct {
-	Status  string `json:"status"`
-	Message string `json:"message,omitempty"`
-	ItemID  int64  `json:"item_id,omitempty"`
+	Status     string `json:"status"`
+	Message    string `json:"message,omitempty"`
+	ItemID     int64  `json:"item_id,omitempty"`
+	PriceCents int    `json:"price_cents,omitempty"`
+	Currency   string `json:"currency,omitempty"`
 }
 
 func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -33,41 +41,44 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	code := r.URL.Query().Get("code")
+	// A code in the JSON body takes precedence over the query parameter, which
+	// is kept as a fallback for existing clients.
+	var code, paymentReference string
+	useBody, errs := hasJSONBody(r)
+	if errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+	if useBody {
+		var req PurchaseRequestPayload
+		if errs := decodeJSONBody(w, r, &req); errs != nil {
+			writeValidationErrors(w, errs)
+			return
+		}
+		code = req.Code
+		paymentReference = req.PaymentReference
+	}
+	if code == "" {
+		code = r.URL.Query().Get("code")
+	}
+	if paymentReference == "" {
+		paymentReference = r.URL.Query().Get("payment_reference")
+	}
 	if code == "" {
 		http.Error(w, "code query parameter is required", http.StatusBadRequest)
 		return
 	}
 
-	purchasedItem, err := h.saleService.ProcessPurchase(r.Context(), code)
+	purchasedItem, err := h.saleService.ProcessPurchase(r.Context(), code, paymentReference)
 	if err != nil {
-		var statusCode int
-		var message string
-
-		switch err {
-		case service.ErrCheckoutCodeInvalid, service.ErrCheckoutCodeExpired, service.ErrCheckoutCodeAlreadyUsed:
-			statusCode = http.StatusBadRequest
-			message = err.Error()
-		case service.ErrSaleNotActive:
-			statusCode = http.StatusServiceUnavailable
-			message = err.Error()
-		case service.ErrItemNotFoundOrSold:
-			statusCode = http.StatusConflict
-			message = "Item is no longer available or already sold"
-		case service.ErrUserLimitReached:
-			statusCode = http.StatusForbidden
-			message = err.Error()
-		case service.ErrSaleLimitReached:
-			statusCode = http.StatusConflict
-			message = err.Error()
-		case service.ErrPurchaseFailed:
-			statusCode = http.StatusInternalServerError
-			message = "Purchase processing failed due to an internal error"
-		default:
-			statusCode = http.StatusInternalServerError
-			message = "An unexpected error occurred during purchase"
+		var notStarted *service.SaleNotStartedError
+		if errors.As(err, &notStarted) {
+			setRetryAfter(w, notStarted.OpensAt)
+		} else if errors.Is(err, service.ErrSaleNotActive) {
+			setRetryAfterNextSale(w, h.saleService)
 		}
 
+		statusCode, message := purchaseErrorStatus(err)
 		resp := PurchaseResponsePayload{Status: "failed", Message: message}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(statusCode)
@@ -76,9 +87,11 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	resp := PurchaseResponsePayload{
-		Status:  "success",
-		Message: "Item purchased successfully",
-		ItemID:  purchasedItem.ID,
+		Status:     "success",
+		Message:    "Item purchased successfully",
+		ItemID:     purchasedItem.ID,
+		PriceCents: purchasedItem.PriceCents,
+		Currency:   purchasedItem.Currency,
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
@@ -86,3 +99,34 @@ func (h *PurchaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		h.logger.Printf("Error encoding purchase response: %v", err)
 	}
 }
+
+// purchaseErrorStatus maps a ProcessPurchase error to its HTTP status and
+// client-facing message.
+func purchaseErrorStatus(err error) (int, string) {
+	var notStarted *service.SaleNotStartedError
+	if errors.As(err, &notStarted) {
+		return http.StatusServiceUnavailable, err.Error()
+	}
+
+	switch err {
+	case service.ErrCheckoutCodeInvalid, service.ErrCheckoutCodeExpired, service.ErrCheckoutCodeAlreadyUsed,
+		service.ErrPaymentReferenceRequired:
+		return http.StatusBadRequest, err.Error()
+	case service.ErrPaymentNotVerified:
+		return http.StatusPaymentRequired, err.Error()
+	case service.ErrPurchaseVelocityExceeded, service.ErrPurchaseTooSoon:
+		return http.StatusTooManyRequests, err.Error()
+	case service.ErrSaleNotActive, service.ErrPurchaseQueueFull:
+		return http.StatusServiceUnavailable, err.Error()
+	case service.ErrItemNotFoundOrSold:
+		return http.StatusConflict, "Item is no longer available or already sold"
+	case service.ErrUserLimitReached, service.ErrGlobalUserLimitReached, service.ErrSKULimitReached:
+		return http.StatusForbidden, err.Error()
+	case service.ErrSaleLimitReached, service.ErrPaymentReferenceUsed:
+		return http.StatusConflict, err.Error()
+	case service.ErrPurchaseFailed:
+		return http.StatusInternalServerError, "Purchase processing failed due to an internal error"
+	default:
+		return http.StatusInternalServerError, "An unexpected error occurred during purchase"
+	}
+}
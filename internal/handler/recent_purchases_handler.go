@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+const defaultRecentPurchasesLimit = 20
+
+type RecentPurchasesHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewRecentPurchasesHandler(logger *log.Logger, saleService *service.SaleService) *RecentPurchasesHandler {
+	return &RecentPurchasesHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type RecentPurchasesResponsePayload struct {
+	SaleID    int64                   `json:"sale_id"`
+	Purchases []models.RecentPurchase `json:"purchases"`
+}
+
+// ServeHTTP serves GET /sales/{id}/recent.
+func (h *RecentPurchasesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	saleID, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid sale id format")
+		return
+	}
+
+	limit, ok := parseLimit(w, r, defaultRecentPurchasesLimit)
+	if !ok {
+		return
+	}
+
+	purchases, err := h.saleService.GetRecentPurchases(r.Context(), saleID, limit)
+	if err != nil {
+		h.logger.Printf("Error getting recent purchases for sale %d: %v", saleID, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+
+	resp := RecentPurchasesResponsePayload{SaleID: saleID, Purchases: purchases}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding recent purchases response: %v", err)
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+type SaleReportsResponsePayload struct {
+	From  time.Time           `json:"from"`
+	To    time.Time           `json:"to"`
+	Sales []models.SaleReport `json:"sales"`
+}
+
+// Reports serves GET /admin/reports?from=...&to=..., as CSV when the client
+// accepts text/csv and JSON otherwise. from and to are RFC 3339 times or
+// YYYY-MM-DD dates (UTC midnight); to defaults to now.
+func (h *AdminHandler) Reports(w http.ResponseWriter, r *http.Request) {
+	var errs []FieldError
+	from, ok := parseReportTime(r.URL.Query().Get("from"))
+	if !ok {
+		errs = append(errs, FieldError{Field: "from", Message: "required, as an RFC 3339 time or YYYY-MM-DD date"})
+	}
+	to := time.Now().UTC()
+	if toStr := r.URL.Query().Get("to"); toStr != "" {
+		if to, ok = parseReportTime(toStr); !ok {
+			errs = append(errs, FieldError{Field: "to", Message: "must be an RFC 3339 time or YYYY-MM-DD date"})
+		}
+	}
+	if errs != nil {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	reports, err := h.saleService.GetSaleReports(r.Context(), from, to)
+	if err != nil {
+		switch err {
+		case service.ErrInvalidReportRange:
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+		default:
+			h.logger.Printf("Error building sales report: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	if acceptsCSV(r) {
+		h.writeReportsCSV(w, reports)
+		return
+	}
+	if err := writeJSON(w, http.StatusOK, SaleReportsResponsePayload{From: from, To: to, Sales: reports}); err != nil {
+		h.logger.Printf("Error encoding sales report response: %v", err)
+	}
+}
+
+func (h *AdminHandler) writeReportsCSV(w http.ResponseWriter, reports []models.SaleReport) {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="sales-report.csv"`)
+	w.WriteHeader(http.StatusOK)
+
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"sale_id", "start_time", "end_time", "duration_seconds", "total_items", "sold_items", "sell_through_percent", "unique_buyers", "unsold_items"})
+	for _, r := range reports {
+		cw.Write([]string{
+			strconv.FormatInt(r.SaleID, 10),
+			r.StartTime.UTC().Format(time.RFC3339),
+			r.EndTime.UTC().Format(time.RFC3339),
+			strconv.FormatInt(r.DurationSeconds, 10),
+			strconv.Itoa(r.TotalItems),
+			strconv.Itoa(r.SoldItems),
+			strconv.FormatFloat(r.SellThroughPercent, 'f', 1, 64),
+			strconv.Itoa(r.UniqueBuyers),
+			formatOptionalInt(r.UnsoldItems),
+		})
+	}
+	cw.Flush()
+	if err := cw.Error(); err != nil {
+		h.logger.Printf("Error writing sales report CSV: %v", err)
+	}
+}
+
+// formatOptionalInt renders nil as an empty CSV cell.
+func formatOptionalInt(n *int) string {
+	if n == nil {
+		return ""
+	}
+	return strconv.Itoa(*n)
+}
+
+func parseReportTime(value string) (time.Time, bool) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, true
+	}
+	if t, err := time.Parse(time.DateOnly, value); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func acceptsCSV(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err == nil && mediaType == "text/csv" {
+			return true
+		}
+	}
+	return false
+}
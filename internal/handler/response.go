@@ -0,0 +1,75 @@
+package handler
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"notcoin_contest/internal/service"
+)
+
+type ErrorResponsePayload struct {
+	Error string `json:"error"`
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, payload any) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	return json.NewEncoder(w).Encode(payload)
+}
+
+func writeJSONError(w http.ResponseWriter, statusCode int, message string) error {
+	return writeJSON(w, statusCode, ErrorResponsePayload{Error: message})
+}
+
+// setRetryAfter advertises, in whole seconds, how long the client should wait
+// before retrying.
+func setRetryAfter(w http.ResponseWriter, at time.Time) {
+	seconds := int(math.Ceil(time.Until(at).Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+// degradedRetryAfter is how soon clients turned away during a database
+// outage are asked to try again.
+const degradedRetryAfter = 5 * time.Second
+
+func setRetryAfterDegraded(w http.ResponseWriter) {
+	setRetryAfter(w, time.Now().Add(degradedRetryAfter))
+}
+
+// setRetryAfterQueued asks a client waiting in the checkout queue to poll
+// again, which also keeps its place.
+func setRetryAfterQueued(w http.ResponseWriter) {
+	setRetryAfter(w, time.Now().Add(service.QueuePollInterval))
+}
+
+// setRetryAfterNextSale points clients turned away for lack of an active sale
+// at the next sale. Nothing is set when its start cannot be estimated.
+func setRetryAfterNextSale(w http.ResponseWriter, saleService *service.SaleService) {
+	if next, err := saleService.NextSaleStart(); err == nil {
+		setRetryAfter(w, next)
+	}
+}
+
+// etagMatches reports whether the request's If-None-Match names etag, using
+// the weak comparison RFC 9110 prescribes for it.
+func etagMatches(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	want := strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == want {
+			return true
+		}
+	}
+	return false
+}
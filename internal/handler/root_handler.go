@@ -0,0 +1,76 @@
+package handler
+
+import "net/http"
+
+const serviceName = "notcoin-backend"
+
+type ServiceInfoResponsePayload struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// ServiceInfo answers GET / with the service name and build version, which
+// is handy for smoke-testing a deployment.
+func ServiceInfo(version string) http.Handler {
+	info := ServiceInfoResponsePayload{Name: serviceName, Version: version}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	})
+}
+
+// BuildInfo is the build metadata stamped into the binary at link time.
+type BuildInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit"`
+	BuiltAt string `json:"built_at"`
+}
+
+// Version answers GET /version with the build metadata, so operators can
+// confirm which build a deployment runs.
+func Version(info BuildInfo) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, info)
+	})
+}
+
+// NotFound serves mux, answering requests that match no route with a JSON
+// 404 instead of the mux's plain-text one. Everything else the mux answers
+// itself, including 405s with their Allow header for routes registered
+// under other methods.
+func NotFound(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(&notFoundWriter{ResponseWriter: w}, r)
+	})
+}
+
+// notFoundWriter replaces a 404 written through it with a JSON error and
+// drops the plain-text body that follows.
+type notFoundWriter struct {
+	http.ResponseWriter
+	replaced bool
+}
+
+func (nw *notFoundWriter) WriteHeader(code int) {
+	if code != http.StatusNotFound {
+		nw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	nw.replaced = true
+	nw.Header().Del("X-Content-Type-Options")
+	writeJSONError(nw.ResponseWriter, http.StatusNotFound, "not found")
+}
+
+func (nw *notFoundWriter) Write(b []byte) (int, error) {
+	if nw.replaced {
+		return len(b), nil
+	}
+	return nw.ResponseWriter.Write(b)
+}
+
+func (nw *notFoundWriter) Unwrap() http.ResponseWriter {
+	return nw.ResponseWriter
+}
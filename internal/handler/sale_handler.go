@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/http"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+const (
+	saleStatePreview = "preview"
+	saleStateOpen    = "open"
+)
+
+type SaleStatusHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewSaleStatusHandler(logger *log.Logger, saleService *service.SaleService) *SaleStatusHandler {
+	return &SaleStatusHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type SaleStatusResponsePayload struct {
+	Sale             *models.Sale `json:"sale"`
+	State            string       `json:"state"`
+	PurchaseOpensAt  time.Time    `json:"purchase_opens_at"`
+	SecondsUntilOpen int          `json:"seconds_until_open"`
+	// Degraded is set when the database is unreachable and the sale is the
+	// last one known; checkouts are refused until it is back.
+	Degraded bool `json:"degraded,omitempty"`
+}
+
+func (h *SaleStatusHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sale, degraded, err := h.saleService.GetCurrentActiveSale(r.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrDatabaseUnavailable) {
+			setRetryAfterDegraded(w)
+			writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+		h.logger.Printf("Error getting active sale: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+	if sale == nil {
+		writeJSONError(w, http.StatusNotFound, service.ErrSaleNotActive.Error())
+		return
+	}
+
+	now := time.Now()
+	resp := SaleStatusResponsePayload{
+		Sale:            sale,
+		State:           saleStateOpen,
+		PurchaseOpensAt: sale.StartTime,
+		Degraded:        degraded,
+	}
+	if service.IsSaleInPreview(sale, now) {
+		resp.State = saleStatePreview
+		resp.SecondsUntilOpen = int(sale.StartTime.Sub(now).Seconds())
+	}
+
+	etag := saleStatusETag(sale, resp.State, degraded)
+	w.Header().Set("ETag", etag)
+	if etagMatches(r, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding sale status response: %v", err)
+	}
+}
+
+// saleStatusETag hashes what a poller of /sales/active cares about: which
+// sale, how much of it is sold, its state and whether it is served degraded.
+// updated_at covers edits to the sale row itself. It is weak because
+// seconds_until_open keeps counting down under the same tag.
+func saleStatusETag(sale *models.Sale, state string, degraded bool) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d|%d|%d|%d|%s|%t", sale.ID, sale.SoldItems, sale.TotalItems, sale.UpdatedAt.UnixNano(), state, degraded)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+type NextSaleHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewNextSaleHandler(logger *log.Logger, saleService *service.SaleService) *NextSaleHandler {
+	return &NextSaleHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type NextSaleResponsePayload struct {
+	NextStart    time.Time `json:"next_start"`
+	SecondsUntil int       `json:"seconds_until"`
+}
+
+func (h *NextSaleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	next, err := h.saleService.NextSaleStart()
+	if err != nil {
+		switch err {
+		case service.ErrNextSaleUnknown:
+			writeJSONError(w, http.StatusNotFound, err.Error())
+		default:
+			h.logger.Printf("Error computing next sale start: %v", err)
+			writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		}
+		return
+	}
+
+	// A late scheduler tick would otherwise report a negative countdown.
+	secondsUntil := int(time.Until(next).Seconds())
+	if secondsUntil < 0 {
+		secondsUntil = 0
+	}
+
+	resp := NextSaleResponsePayload{NextStart: next, SecondsUntil: secondsUntil}
+	if err := writeJSON(w, http.StatusOK, resp); err != nil {
+		h.logger.Printf("Error encoding next sale response: %v", err)
+	}
+}
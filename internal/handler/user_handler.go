@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/service"
+)
+
+type UserLimitHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewUserLimitHandler(logger *log.Logger, saleService *service.SaleService) *UserLimitHandler {
+	return &UserLimitHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+func (h *UserLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	limit, err := h.saleService.GetUserLimit(r.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Error getting purchase limit for user %s: %v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+
+	if err := writeJSON(w, http.StatusOK, limit); err != nil {
+		h.logger.Printf("Error encoding user limit response: %v", err)
+	}
+}
+
+// userSalesMaxAge is how long clients may reuse a user's sale history. A
+// purchase only adds to it, so a briefly stale copy is harmless.
+const userSalesMaxAge = "10"
+
+type UserSalesHandler struct {
+	logger      *log.Logger
+	saleService *service.SaleService
+}
+
+func NewUserSalesHandler(logger *log.Logger, saleService *service.SaleService) *UserSalesHandler {
+	return &UserSalesHandler{
+		logger:      logger,
+		saleService: saleService,
+	}
+}
+
+type UserSalesResponsePayload struct {
+	UserID string            `json:"user_id"`
+	Sales  []models.UserSale `json:"sales"`
+}
+
+// ServeHTTP serves GET /users/{userID}/sales.
+func (h *UserSalesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("userID")
+
+	sales, err := h.saleService.GetUserSales(r.Context(), userID)
+	if err != nil {
+		h.logger.Printf("Error getting sales for user %s: %v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+		return
+	}
+
+	w.Header().Set("Cache-Control", "private, max-age="+userSalesMaxAge)
+	if err := writeJSON(w, http.StatusOK, UserSalesResponsePayload{UserID: userID, Sales: sales}); err != nil {
+		h.logger.Printf("Error encoding user sales response: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net/http"
+
+	"notcoin_contest/internal/service"
+)
+
+type UserListResponsePayload struct {
+	List  string   `json:"list"`
+	Users []string `json:"users"`
+}
+
+// GetUserList serves GET /admin/user-lists/{list}.
+func (h *AdminHandler) GetUserList(w http.ResponseWriter, r *http.Request) {
+	list := r.PathValue("list")
+
+	users, err := h.saleService.GetUserList(r.Context(), list)
+	if err != nil {
+		h.writeUserListError(w, list, err)
+		return
+	}
+
+	if users == nil {
+		users = []string{}
+	}
+	if err := writeJSON(w, http.StatusOK, UserListResponsePayload{List: list, Users: users}); err != nil {
+		h.logger.Printf("Error encoding user list response: %v", err)
+	}
+}
+
+// AddToUserList serves PUT /admin/user-lists/{list}/{userID}.
+func (h *AdminHandler) AddToUserList(w http.ResponseWriter, r *http.Request) {
+	list := r.PathValue("list")
+	if err := h.saleService.AddToUserList(r.Context(), list, r.PathValue("userID")); err != nil {
+		h.writeUserListError(w, list, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RemoveFromUserList serves DELETE /admin/user-lists/{list}/{userID}.
+func (h *AdminHandler) RemoveFromUserList(w http.ResponseWriter, r *http.Request) {
+	list := r.PathValue("list")
+	if err := h.saleService.RemoveFromUserList(r.Context(), list, r.PathValue("userID")); err != nil {
+		h.writeUserListError(w, list, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *AdminHandler) writeUserListError(w http.ResponseWriter, list string, err error) {
+	switch err {
+	case service.ErrUnknownUserList:
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	default:
+		h.logger.Printf("Error updating user list %s: %v", list, err)
+		writeJSONError(w, http.StatusInternalServerError, "An unexpected error occurred")
+	}
+}
@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const maxJSONBodyBytes = 1 << 20
+
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type ValidationErrorResponsePayload struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func writeValidationErrors(w http.ResponseWriter, errs []FieldError) error {
+	return writeJSON(w, http.StatusBadRequest, ValidationErrorResponsePayload{Errors: errs})
+}
+
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/json"
+}
+
+// hasJSONBody reports whether an endpoint that also takes query parameters
+// should read a JSON body. Requests without a body use the query, whatever
+// their Content-Type; a body sent as anything but JSON is rejected.
+func hasJSONBody(r *http.Request) (bool, []FieldError) {
+	if r.ContentLength == 0 {
+		return false, nil
+	}
+	if !isJSONRequest(r) {
+		return false, []FieldError{{Field: "Content-Type", Message: "must be application/json"}}
+	}
+	return true, nil
+}
+
+// decodeJSONBody decodes the request body into dst and translates decoding
+// failures into field-level validation errors. The body must be sent as
+// application/json and may only contain fields dst knows about, so that a
+// misspelt field fails loudly instead of being ignored.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, dst any) []FieldError {
+	if !isJSONRequest(r) {
+		return []FieldError{{Field: "Content-Type", Message: "must be application/json"}}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err == nil {
+		if dec.More() {
+			return []FieldError{{Field: "body", Message: "must contain a single JSON object"}}
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, io.EOF):
+		return []FieldError{{Field: "body", Message: "required"}}
+	case errors.As(err, &syntaxErr), errors.Is(err, io.ErrUnexpectedEOF):
+		return []FieldError{{Field: "body", Message: "malformed JSON"}}
+	case errors.As(err, &typeErr):
+		field := typeErr.Field
+		if field == "" {
+			field = "body"
+		}
+		return []FieldError{{Field: field, Message: fmt.Sprintf("must be of type %s", typeErr.Type)}}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for unknown fields.
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return []FieldError{{Field: field, Message: "unknown field"}}
+	case errors.As(err, &maxBytesErr):
+		return []FieldError{{Field: "body", Message: fmt.Sprintf("must not exceed %d bytes", maxBytesErr.Limit)}}
+	default:
+		return []FieldError{{Field: "body", Message: err.Error()}}
+	}
+}
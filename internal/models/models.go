@@ -3,26 +3,35 @@ package models
 import "time"
 
 type Item struct {
-	ID        int64     `json:"id"`
-	SaleID    int64     `json:"sale_id"`
-	Name      string    `json:"name"`
-	ImageURL  string    `json:"image_url"`
-	IsSold    bool      `json:"is_sold"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-}
-
-type Sale struct {
 	ID         int64     `json:"id"`
-	StartTime  time.Time `json:"start_time"`
-	EndTime    time.Time `json:"end_time"`
-	TotalItems int       `json:"total_items"`
-	SoldItems  int       `json:"sold_items"`
-	IsActive   bool      `json:"is_active"`
+	SaleID     int64     `json:"sale_id"`
+	Name       string    `json:"name"`
+	ImageURL   string    `json:"image_url"`
+	PriceCents int       `json:"price_cents"`
+	Currency   string    `json:"currency"`
+	SKU        string    `json:"sku,omitempty"`
+	IsSold     bool      `json:"is_sold"`
+	IsDisabled bool      `json:"is_disabled"`
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
 }
 
+type Sale struct {
+	ID              int64      `json:"id"`
+	Title           string     `json:"title,omitempty"`
+	Category        string     `json:"category,omitempty"`
+	PreviewStart    *time.Time `json:"preview_start,omitempty"`
+	StartTime       time.Time  `json:"start_time"`
+	EndTime         time.Time  `json:"end_time"`
+	TotalItems      int        `json:"total_items"`
+	SoldItems       int        `json:"sold_items"`
+	IsActive        bool       `json:"is_active"`
+	MaxItemsPerUser *int       `json:"max_items_per_user,omitempty"`
+	DurationSeconds *int       `json:"duration_seconds,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
 type CheckoutAttempt struct {
 	ID        string    `json:"id"`
 	UserID    string    `json:"user_id"`
@@ -30,6 +39,8 @@ type CheckoutAttempt struct {
 	SaleID    int64     `json:"sale_id"`
 	ExpiresAt time.Time `json:"expires_at"`
 	IsUsed    bool      `json:"is_used"`
+	ClientIP  string    `json:"client_ip,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
 	CreatedAt time.Time `json:"created_at"`
 }
 
@@ -48,3 +59,147 @@ type UserSaleSummary struct {
 	SaleID         int64  `json:"sale_id"`
 	ItemsPurchased int    `json:"items_purchased"`
 }
+
+// UserSale is one sale a user bought items in.
+type UserSale struct {
+	SaleID         int64     `json:"sale_id"`
+	Title          string    `json:"title,omitempty"`
+	Category       string    `json:"category,omitempty"`
+	StartTime      time.Time `json:"start_time"`
+	EndTime        time.Time `json:"end_time"`
+	ItemsPurchased int       `json:"items_purchased"`
+}
+
+type SaleSummary struct {
+	SaleID                 int64   `json:"sale_id"`
+	TotalItems             int     `json:"total_items"`
+	SoldItems              int     `json:"sold_items"`
+	SellThroughPercent     float64 `json:"sell_through_percent"`
+	UniqueBuyers           int     `json:"unique_buyers"`
+	PeakCheckoutsPerMinute int     `json:"peak_checkouts_per_minute"`
+}
+
+// SaleReport is one sale's row in the admin sales report.
+type SaleReport struct {
+	SaleID             int64     `json:"sale_id"`
+	StartTime          time.Time `json:"start_time"`
+	EndTime            time.Time `json:"end_time"`
+	DurationSeconds    int64     `json:"duration_seconds"`
+	TotalItems         int       `json:"total_items"`
+	SoldItems          int       `json:"sold_items"`
+	SellThroughPercent float64   `json:"sell_through_percent"`
+	UniqueBuyers       int       `json:"unique_buyers"`
+	// UnsoldItems is the leftover count recorded when the sale ended, or
+	// nil while the sale has not yet been closed out.
+	UnsoldItems *int `json:"unsold_items"`
+}
+
+// RecentPurchase is one entry of a sale's recently sold ticker.
+type RecentPurchase struct {
+	ItemID   int64  `json:"item_id"`
+	ItemName string `json:"item_name"`
+	// Buyer is an anonymized form of the buyer's user ID.
+	Buyer       string    `json:"buyer"`
+	PurchasedAt time.Time `json:"purchased_at"`
+}
+
+// LeaderboardEntry is one buyer's place in a sale's leaderboard.
+type LeaderboardEntry struct {
+	Rank int `json:"rank"`
+	// Buyer is an anonymized form of the buyer's user ID.
+	Buyer          string `json:"buyer"`
+	ItemsPurchased int    `json:"items_purchased"`
+}
+
+// SalePurge counts the rows deleted when a finished sale was purged.
+type SalePurge struct {
+	SaleID           int64 `json:"sale_id"`
+	Purchases        int64 `json:"purchases"`
+	CheckoutAttempts int64 `json:"checkout_attempts"`
+	UserLimits       int64 `json:"user_limits"`
+	Items            int64 `json:"items"`
+}
+
+// SaleStats is a point-in-time snapshot of a sale for the admin dashboard.
+type SaleStats struct {
+	SaleID             int64 `json:"sale_id"`
+	TotalItems         int   `json:"total_items"`
+	SoldItems          int   `json:"sold_items"`
+	DistinctPurchasers int   `json:"distinct_purchasers"`
+	ActiveCheckouts    int   `json:"active_checkouts"`
+}
+
+type UserLimit struct {
+	UserID     string `json:"user_id"`
+	SaleID     int64  `json:"sale_id,omitempty"`
+	SaleActive bool   `json:"sale_active"`
+	Limit      int    `json:"limit"`
+	Used       int    `json:"used"`
+	Remaining  int    `json:"remaining"`
+	Items      []Item `json:"items"`
+}
+
+type IPCheckoutCount struct {
+	ClientIP      string `json:"client_ip"`
+	Checkouts     int    `json:"checkouts"`
+	DistinctUsers int    `json:"distinct_users"`
+}
+
+// PoolStats reports the connection pools of this instance.
+type PoolStats struct {
+	Primary DBPoolStats `json:"primary"`
+	// Replica is absent when reads go to the primary.
+	Replica *DBPoolStats   `json:"replica,omitempty"`
+	Redis   RedisPoolStats `json:"redis"`
+}
+
+// DBPoolStats mirrors sql.DBStats. A growing WaitCount means requests queue
+// for a connection and MaxOpenConns may be too low.
+type DBPoolStats struct {
+	MaxOpenConnections int   `json:"max_open_connections"`
+	OpenConnections    int   `json:"open_connections"`
+	InUse              int   `json:"in_use"`
+	Idle               int   `json:"idle"`
+	WaitCount          int64 `json:"wait_count"`
+	WaitDurationMs     int64 `json:"wait_duration_ms"`
+	MaxIdleClosed      int64 `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64 `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64 `json:"max_lifetime_closed"`
+}
+
+// RedisPoolStats mirrors the go-redis pool statistics.
+type RedisPoolStats struct {
+	Hits       uint32 `json:"hits"`
+	Misses     uint32 `json:"misses"`
+	Timeouts   uint32 `json:"timeouts"`
+	TotalConns uint32 `json:"total_conns"`
+	IdleConns  uint32 `json:"idle_conns"`
+	StaleConns uint32 `json:"stale_conns"`
+}
+
+type MaintenanceStatus struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+}
+
+// SaleFunnel summarizes how checkouts in a sale converted into purchases.
+type SaleFunnel struct {
+	SaleID             int64            `json:"sale_id"`
+	CheckoutsCreated   int64            `json:"checkouts_created"`
+	CheckoutsExpired   int64            `json:"checkouts_expired"`
+	PurchasesSucceeded int64            `json:"purchases_succeeded"`
+	PurchasesFailed    int64            `json:"purchases_failed"`
+	FailuresByReason   map[string]int64 `json:"failures_by_reason"`
+	ConversionPercent  float64          `json:"conversion_percent"`
+	ExpiredPercent     float64          `json:"expired_percent"`
+}
+
+type CheckoutStatus struct {
+	Code      string    `json:"code"`
+	Valid     bool      `json:"valid"`
+	Used      bool      `json:"used"`
+	ExpiresAt time.Time `json:"expires_at"`
+	SaleID    int64     `json:"sale_id"`
+	ItemID    int64     `json:"item_id"`
+	Reason    string    `json:"reason,omitempty"`
+}
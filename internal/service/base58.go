@@ -0,0 +1,27 @@
+package service
+
+import (
+	"math"
+	"math/big"
+)
+
+// base58Alphabet is the Bitcoin alphabet, which leaves out 0, O, I and l so
+// codes read back unambiguously.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// encodeBase58 encodes b in base58, left-padded with the zero digit to the
+// length the largest value of len(b) bytes needs. Codes of one size thus all
+// have one length, and are never short enough to pass for a checkout alias.
+func encodeBase58(b []byte) string {
+	width := int(math.Ceil(float64(len(b)) * 8 / math.Log2(58)))
+	out := make([]byte, width)
+
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	digit := new(big.Int)
+	for i := width - 1; i >= 0; i-- {
+		n.DivMod(n, radix, digit)
+		out[i] = base58Alphabet[digit.Int64()]
+	}
+	return string(out)
+}
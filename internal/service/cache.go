@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// getCached decodes the response cached under name into v and reports
+// whether there was one. Redis failures and undecodable entries count as
+// misses, so callers fall back to the database.
+func (s *SaleService) getCached(ctx context.Context, name string, v any) bool {
+	data, err := s.redisStore.GetCache(ctx, name)
+	if err != nil {
+		s.logger.Printf("Warning: failed to read cached %s: %v\n", name, err)
+		return false
+	}
+	if data == nil {
+		return false
+	}
+	return json.Unmarshal(data, v) == nil
+}
+
+// setCached caches v under name for ttl. Failures are logged and otherwise
+// ignored.
+func (s *SaleService) setCached(ctx context.Context, name string, v any, ttl time.Duration) {
+	data, err := json.Marshal(v)
+	if err == nil {
+		err = s.redisStore.SetCache(ctx, name, data, ttl)
+	}
+	if err != nil {
+		s.logger.Printf("Warning: failed to cache %s: %v\n", name, err)
+	}
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	cRand "crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+const (
+	// checkoutAliasAlphabet leaves out characters that are easily misread
+	// (0/O, 1/I/L), so aliases can be typed from a screen or a receipt.
+	checkoutAliasAlphabet = "23456789ABCDEFGHJKMNPQRSTUVWXYZ"
+	// CheckoutAliasLength keeps aliases short enough to read out while
+	// leaving about 49 bits to guess. Full codes are far longer hex strings,
+	// so the two never look alike.
+	CheckoutAliasLength        = 10
+	maxAliasGenerationAttempts = 5
+)
+
+// CreateCheckoutAlias issues a short alias for a checkout code, usable in
+// its place at /purchase until the code expires.
+func (s *SaleService) CreateCheckoutAlias(ctx context.Context, code string) (string, error) {
+	for i := 0; i < maxAliasGenerationAttempts; i++ {
+		alias, err := generateCheckoutAlias()
+		if err != nil {
+			return "", fmt.Errorf("failed to generate checkout alias: %w", err)
+		}
+		stored, err := s.redisStore.StoreCheckoutAlias(ctx, alias, code, s.cfg().CodeTTLExpiry)
+		if err != nil {
+			return "", err
+		}
+		if stored {
+			return alias, nil
+		}
+		s.logger.Printf("Warning: generated checkout alias collided with an existing one, regenerating.\n")
+	}
+	return "", fmt.Errorf("could not generate a unique checkout alias after %d attempts", maxAliasGenerationAttempts)
+}
+
+// resolveCheckoutCode returns the full code behind an alias, and any other
+// code unchanged. Unknown aliases are ErrCheckoutCodeInvalid.
+func (s *SaleService) resolveCheckoutCode(ctx context.Context, code string) (string, error) {
+	if !isCheckoutAlias(code) {
+		return code, nil
+	}
+	fullCode, err := s.redisStore.GetCheckoutAlias(ctx, strings.ToUpper(code))
+	if err != nil {
+		s.logger.Printf("Error resolving checkout alias %s: %v\n", code, err)
+		return "", ErrPurchaseFailed
+	}
+	if fullCode == "" {
+		return "", ErrCheckoutCodeInvalid
+	}
+	return fullCode, nil
+}
+
+func isCheckoutAlias(code string) bool {
+	if len(code) != CheckoutAliasLength {
+		return false
+	}
+	for _, c := range strings.ToUpper(code) {
+		if !strings.ContainsRune(checkoutAliasAlphabet, c) {
+			return false
+		}
+	}
+	return true
+}
+
+func generateCheckoutAlias() (string, error) {
+	var b strings.Builder
+	base := big.NewInt(int64(len(checkoutAliasAlphabet)))
+	for i := 0; i < CheckoutAliasLength; i++ {
+		n, err := cRand.Int(cRand.Reader, base)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(checkoutAliasAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
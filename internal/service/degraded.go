@@ -0,0 +1,105 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/store"
+)
+
+// ErrDatabaseUnavailable is returned when Postgres cannot be reached and
+// there is nothing cached to answer with instead.
+var ErrDatabaseUnavailable = errors.New("database is temporarily unavailable, try again shortly")
+
+// lastActiveSaleCacheName holds the last active sale read from the database,
+// shared by all replicas so any of them can keep answering during an outage.
+const lastActiveSaleCacheName = "sale:active:last"
+
+// rememberActiveSale records sale as the last known active sale. Redis is
+// only written when the sale or its sold count changed, so polling the
+// active sale costs no extra Redis round trip.
+func (s *SaleService) rememberActiveSale(ctx context.Context, sale *models.Sale) {
+	prev := s.lastActiveSale.Swap(sale)
+	if prev != nil && prev.ID == sale.ID && prev.SoldItems == sale.SoldItems && prev.IsActive == sale.IsActive {
+		return
+	}
+	if ttl := time.Until(sale.EndTime); ttl > 0 {
+		s.setCached(ctx, lastActiveSaleCacheName, sale, ttl)
+	}
+}
+
+// lastKnownActiveSale returns the last active sale seen by any replica, or
+// nil when there is none or it has ended since. The Redis copy is preferred
+// because other replicas keep it fresher than this process's own. Its sold
+// count is as of that read, raised to the total once the sale's sold-out
+// flag is set.
+func (s *SaleService) lastKnownActiveSale(ctx context.Context) *models.Sale {
+	var sale models.Sale
+	if !s.getCached(ctx, lastActiveSaleCacheName, &sale) {
+		last := s.lastActiveSale.Load()
+		if last == nil {
+			return nil
+		}
+		sale = *last
+	}
+	if time.Now().After(sale.EndTime) {
+		return nil
+	}
+
+	soldOut, err := s.redisStore.IsSaleSoldOut(ctx, sale.ID)
+	if err != nil {
+		s.logger.Printf("Warning: failed to check sold-out flag for sale %d: %v\n", sale.ID, err)
+	}
+	if soldOut {
+		sale.SoldItems = sale.TotalItems
+	}
+	return &sale
+}
+
+// forgetActiveSale drops the remembered active sale if it is saleID, so a
+// deleted or ended sale is never served during an outage.
+func (s *SaleService) forgetActiveSale(ctx context.Context, saleID int64) {
+	if last := s.lastActiveSale.Load(); last != nil && last.ID == saleID {
+		s.lastActiveSale.CompareAndSwap(last, nil)
+	}
+	var cached models.Sale
+	if s.getCached(ctx, lastActiveSaleCacheName, &cached) && cached.ID == saleID {
+		if err := s.redisStore.DeleteCache(ctx, lastActiveSaleCacheName); err != nil {
+			s.logger.Printf("Warning: failed to forget cached active sale ID %d: %v\n", saleID, err)
+		}
+	}
+}
+
+// activeSaleOrLastKnown reads the active sale through read, remembering it
+// for later outages. When the database is unreachable it falls back to the
+// last known active sale and reports degraded; with none it returns
+// ErrDatabaseUnavailable.
+func (s *SaleService) activeSaleOrLastKnown(ctx context.Context, read func() (*models.Sale, error)) (_ *models.Sale, degraded bool, err error) {
+	sale, err := traceStore(ctx, "GetActiveSale", read)
+	if err == nil {
+		if sale != nil {
+			s.rememberActiveSale(ctx, sale)
+		}
+		return sale, false, nil
+	}
+	if !store.IsUnavailableError(err) {
+		return nil, false, err
+	}
+
+	s.logger.Printf("Warning: database unavailable, serving the last known active sale: %v\n", err)
+	if sale := s.lastKnownActiveSale(ctx); sale != nil {
+		return sale, true, nil
+	}
+	return nil, false, ErrDatabaseUnavailable
+}
+
+// mapUnavailable replaces an error caused by an unreachable database with
+// ErrDatabaseUnavailable, so write paths fail with a clear 503 instead of an
+// opaque 500. Deferred with a pointer to the caller's named error.
+func mapUnavailable(err *error) {
+	if *err != nil && store.IsUnavailableError(*err) {
+		*err = ErrDatabaseUnavailable
+	}
+}
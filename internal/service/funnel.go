@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"notcoin_contest/internal/models"
+)
+
+// Funnel counters live in one Redis hash per sale. Failed purchases are
+// counted per reason under the funnelPurchaseFailed prefix.
+const (
+	funnelCheckoutCreated = "checkout_created"
+	funnelPurchaseSuccess = "purchase_success"
+	funnelPurchaseFailed  = "purchase_failed:"
+	funnelReasonExpired   = "code_expired"
+	funnelCountersTTL     = 7 * 24 * time.Hour
+)
+
+// recordFunnel increments a funnel counter when FUNNEL_TRACKING is enabled.
+// Failures are logged and never affect the request.
+func (s *SaleService) recordFunnel(ctx context.Context, saleID int64, field string) {
+	if !s.cfg().FunnelTracking {
+		return
+	}
+	if err := s.redisStore.IncrFunnelCounter(ctx, saleID, field, funnelCountersTTL); err != nil {
+		s.logger.Printf("Warning: failed to record funnel event %s for sale %d: %v\n", field, saleID, err)
+	}
+}
+
+func (s *SaleService) recordPurchaseOutcome(ctx context.Context, saleID int64, err error) {
+	if err == nil {
+		s.recordFunnel(ctx, saleID, funnelPurchaseSuccess)
+		return
+	}
+	s.recordFunnel(ctx, saleID, funnelPurchaseFailed+funnelReason(err))
+}
+
+func funnelReason(err error) string {
+	switch {
+	case errors.Is(err, ErrCheckoutCodeExpired):
+		return funnelReasonExpired
+	case errors.Is(err, ErrCheckoutCodeAlreadyUsed):
+		return "code_used"
+	case errors.Is(err, ErrSaleNotStarted):
+		return "sale_not_started"
+	case errors.Is(err, ErrSaleNotActive):
+		return "sale_not_active"
+	case errors.Is(err, ErrItemNotFoundOrSold):
+		return "item_sold"
+	case errors.Is(err, ErrSaleLimitReached):
+		return "sold_out"
+	case errors.Is(err, ErrUserLimitReached):
+		return "user_limit"
+	case errors.Is(err, ErrGlobalUserLimitReached):
+		return "global_limit"
+	case errors.Is(err, ErrSKULimitReached):
+		return "sku_limit"
+	case errors.Is(err, ErrPurchaseVelocityExceeded):
+		return "velocity"
+	case errors.Is(err, ErrPurchaseTooSoon):
+		return "too_soon"
+	case errors.Is(err, ErrPurchaseQueueFull):
+		return "queue_full"
+	case errors.Is(err, ErrPaymentReferenceRequired), errors.Is(err, ErrPaymentNotVerified),
+		errors.Is(err, ErrPaymentReferenceUsed):
+		return "payment"
+	default:
+		return "internal"
+	}
+}
+
+// GetSaleFunnel reads a sale's funnel counters and derives conversion rates
+// relative to the checkouts created. Expired checkouts are counted from the
+// database, since codes expire without any request to record them.
+func (s *SaleService) GetSaleFunnel(ctx context.Context, saleID int64) (*models.SaleFunnel, error) {
+	counters, err := s.redisStore.GetFunnelCounters(ctx, saleID)
+	if err != nil {
+		return nil, err
+	}
+
+	funnel := &models.SaleFunnel{
+		SaleID:             saleID,
+		CheckoutsCreated:   counters[funnelCheckoutCreated],
+		PurchasesSucceeded: counters[funnelPurchaseSuccess],
+		FailuresByReason:   map[string]int64{},
+	}
+	for field, n := range counters {
+		if reason, ok := strings.CutPrefix(field, funnelPurchaseFailed); ok {
+			funnel.FailuresByReason[reason] = n
+			funnel.PurchasesFailed += n
+		}
+	}
+	expired, err := s.readStore.CountExpiredCheckoutAttempts(saleID)
+	if err != nil {
+		return nil, err
+	}
+	funnel.CheckoutsExpired = expired
+	if funnel.CheckoutsCreated > 0 {
+		funnel.ConversionPercent = float64(funnel.PurchasesSucceeded) * 100 / float64(funnel.CheckoutsCreated)
+		funnel.ExpiredPercent = float64(funnel.CheckoutsExpired) * 100 / float64(funnel.CheckoutsCreated)
+	}
+	return funnel, nil
+}
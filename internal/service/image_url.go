@@ -0,0 +1,89 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"notcoin_contest/internal/models"
+)
+
+// ErrInvalidImageURL is matched by InvalidImageURLError.
+var ErrInvalidImageURL = errors.New("invalid image URL")
+
+// InvalidImageURLError names the item or template whose image URL was
+// refused and why. It matches ErrInvalidImageURL with errors.Is.
+type InvalidImageURLError struct {
+	Entry  string
+	URL    string
+	Reason string
+}
+
+func (e *InvalidImageURLError) Error() string {
+	return fmt.Sprintf("%s: %s %q: %s", e.Entry, ErrInvalidImageURL, e.URL, e.Reason)
+}
+
+func (e *InvalidImageURLError) Is(target error) bool {
+	return target == ErrInvalidImageURL
+}
+
+// imageURLProblem explains why raw, once resolved against ImageBaseURL as
+// the API would return it, is not an absolute http or https URL with a host.
+// It returns "" for a valid URL.
+func (s *SaleService) imageURLProblem(raw string) string {
+	item := models.Item{ImageURL: raw}
+	s.resolveImageURL(&item)
+
+	u, err := url.Parse(item.ImageURL)
+	if err != nil {
+		return "does not parse"
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+	case "":
+		return "is relative and IMAGE_BASE_URL is empty"
+	default:
+		return "must use http or https"
+	}
+	if u.Host == "" {
+		return "has no host"
+	}
+	return ""
+}
+
+// validateItemImages checks the image URL of every item about to be
+// created, when ValidateImageURLs is set.
+func (s *SaleService) validateItemImages(items []models.Item) error {
+	if !s.cfg().ValidateImageURLs {
+		return nil
+	}
+	for i, item := range items {
+		if reason := s.imageURLProblem(item.ImageURL); reason != "" {
+			return &InvalidImageURLError{
+				Entry:  fmt.Sprintf("item %d (%q)", i+1, item.Name),
+				URL:    item.ImageURL,
+				Reason: reason,
+			}
+		}
+	}
+	return nil
+}
+
+// validateImageTemplate checks the URL a ReimageSale template yields for a
+// sample item of the sale, when ValidateImageURLs is set.
+func (s *SaleService) validateImageTemplate(saleID int64, template string) error {
+	if !s.cfg().ValidateImageURLs {
+		return nil
+	}
+	sample := strings.NewReplacer(
+		"{sale_id}", strconv.FormatInt(saleID, 10),
+		"{item_id}", "1",
+		"{file}", "image.png",
+	).Replace(template)
+	if reason := s.imageURLProblem(sample); reason != "" {
+		return &InvalidImageURLError{Entry: "template", URL: template, Reason: reason}
+	}
+	return nil
+}
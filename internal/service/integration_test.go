@@ -0,0 +1,293 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/redis/go-redis/v9"
+
+	"notcoin_contest/internal/config"
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/store"
+	"notcoin_contest/migrations"
+)
+
+// The integration tests drive the service against a real Postgres and
+// Redis, named by these variables, and are skipped when either is unset.
+const (
+	testDatabaseURL = "TEST_DATABASE_URL"
+	testRedisAddr   = "TEST_REDIS_ADDR"
+)
+
+// integrationService builds a service over a fresh database schema with
+// every migration applied and a Redis key prefix of its own. Both are
+// removed when the test ends.
+func integrationService(t *testing.T, cfg *config.Config) (*SaleService, *store.DBStore, *store.RedisStore) {
+	t.Helper()
+	dsn, addr := os.Getenv(testDatabaseURL), os.Getenv(testRedisAddr)
+	if dsn == "" || addr == "" {
+		t.Skipf("%s and %s must both be set", testDatabaseURL, testRedisAddr)
+	}
+	suffix := randomTestHex(t)
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+	schema := "test_" + suffix
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		t.Fatalf("%s must be a postgres:// URL: %v", testDatabaseURL, err)
+	}
+	q := u.Query()
+	q.Set("search_path", schema+",public")
+	u.RawQuery = q.Encode()
+	db, err := sql.Open("postgres", u.String())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+	if err := store.RunMigrations(db, migrations.FS); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+
+	client := redis.NewClient(&redis.Options{Addr: addr})
+	prefix := "test:" + suffix + ":"
+	t.Cleanup(func() {
+		ctx := context.Background()
+		keys, err := client.Keys(ctx, prefix+"*").Result()
+		if err == nil && len(keys) > 0 {
+			client.Del(ctx, keys...)
+		}
+		client.Close()
+	})
+
+	dbStore := store.NewDBStore(db)
+	redisStore := store.NewRedisStore(client, prefix, time.Second)
+	return NewSaleService(log.New(io.Discard, "", 0), dbStore, nil, redisStore, cfg), dbStore, redisStore
+}
+
+// integrationConfig is the configuration the integration tests start from:
+// no optional purchase hurdles and every code checked in the database.
+func integrationConfig() *config.Config {
+	return &config.Config{
+		CodeTTLExpiry:     5 * time.Minute,
+		MaxItemsPerUser:   10,
+		PurchaseIsolation: "read_committed",
+	}
+}
+
+// seedIntegrationSale creates an active sale, open for the next hour, with
+// n unsold items.
+func seedIntegrationSale(t *testing.T, db *store.DBStore, n int) (*models.Sale, []models.Item) {
+	t.Helper()
+	now := time.Now()
+	sale, err := db.CreateSale(&models.Sale{
+		StartTime:  now.Add(-time.Minute),
+		EndTime:    now.Add(time.Hour),
+		TotalItems: n,
+		IsActive:   true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create sale: %v", err)
+	}
+	items := make([]models.Item, n)
+	for i := range items {
+		items[i] = models.Item{SaleID: sale.ID, Name: fmt.Sprintf("Item #%d", i+1), Currency: "USD"}
+	}
+	items, err = db.CreateItemsBatch(items)
+	if err != nil {
+		t.Fatalf("failed to create items: %v", err)
+	}
+	return sale, items
+}
+
+func newTestAttempt(t *testing.T, userID string, item models.Item) *models.CheckoutAttempt {
+	return &models.CheckoutAttempt{
+		ID:        randomTestHex(t),
+		UserID:    userID,
+		ItemID:    item.ID,
+		SaleID:    item.SaleID,
+		ExpiresAt: time.Now().Add(5 * time.Minute),
+		CreatedAt: time.Now(),
+	}
+}
+
+func randomTestHex(t *testing.T) string {
+	t.Helper()
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to generate random bytes: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestProcessPurchaseRejectsCodeOnlyInRedis(t *testing.T) {
+	s, db, rdb := integrationService(t, integrationConfig())
+	_, items := seedIntegrationSale(t, db, 1)
+
+	// The code reached Redis but its database write never happened.
+	attempt := newTestAttempt(t, "user-1", items[0])
+	if err := rdb.StoreCheckoutCode(t.Context(), attempt, time.Minute); err != nil {
+		t.Fatalf("failed to store checkout code: %v", err)
+	}
+
+	_, err := s.ProcessPurchase(t.Context(), attempt.ID, "")
+	if !errors.Is(err, ErrCheckoutCodeInvalid) {
+		t.Fatalf("err = %v, want %v", err, ErrCheckoutCodeInvalid)
+	}
+
+	cached, err := rdb.GetCheckoutAttempt(t.Context(), attempt.ID)
+	if err != nil {
+		t.Fatalf("failed to read checkout code: %v", err)
+	}
+	if cached != nil {
+		t.Fatal("orphaned code is still in Redis")
+	}
+	item, err := db.GetItemByID(items[0].ID)
+	if err != nil {
+		t.Fatalf("failed to read item: %v", err)
+	}
+	if item.IsSold {
+		t.Fatal("item was sold to a code the database does not know")
+	}
+}
+
+func TestProcessPurchaseReplayConcurrently(t *testing.T) {
+	s, db, rdb := integrationService(t, integrationConfig())
+	_, items := seedIntegrationSale(t, db, 2)
+
+	attempt := newTestAttempt(t, "user-1", items[0])
+	if err := db.CreateCheckoutAttempt(attempt); err != nil {
+		t.Fatalf("failed to create checkout attempt: %v", err)
+	}
+	if err := rdb.StoreCheckoutCode(t.Context(), attempt, time.Minute); err != nil {
+		t.Fatalf("failed to store checkout code: %v", err)
+	}
+
+	// Every request reads the cached, unused copy before any purchase
+	// commits; only the database can tell them apart.
+	const tries = 5
+	errs := make([]error, tries)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, errs[i] = s.ProcessPurchase(t.Context(), attempt.ID, "")
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	var sold int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case errors.Is(err, ErrCheckoutCodeAlreadyUsed):
+		default:
+			t.Fatalf("unexpected purchase error: %v", err)
+		}
+	}
+	if sold != 1 {
+		t.Fatalf("code bought %d items, want 1", sold)
+	}
+
+	cached, err := rdb.GetCheckoutAttempt(t.Context(), attempt.ID)
+	if err != nil {
+		t.Fatalf("failed to read checkout code: %v", err)
+	}
+	if cached != nil && !cached.IsUsed {
+		t.Fatal("Redis still holds an unused copy of the code")
+	}
+	if _, err := s.ProcessPurchase(t.Context(), attempt.ID, ""); !errors.Is(err, ErrCheckoutCodeAlreadyUsed) {
+		t.Fatalf("replay after the purchase: err = %v, want %v", err, ErrCheckoutCodeAlreadyUsed)
+	}
+}
+
+func TestProcessPurchaseRejectsReleasedCodeStillInRedis(t *testing.T) {
+	s, db, rdb := integrationService(t, integrationConfig())
+	_, items := seedIntegrationSale(t, db, 1)
+
+	attempt := newTestAttempt(t, "user-1", items[0])
+	if err := db.CreateCheckoutAttempt(attempt); err != nil {
+		t.Fatalf("failed to create checkout attempt: %v", err)
+	}
+	if err := rdb.StoreCheckoutCode(t.Context(), attempt, time.Minute); err != nil {
+		t.Fatalf("failed to store checkout code: %v", err)
+	}
+
+	// The release expired the code in the database, but deleting its Redis
+	// copy failed.
+	if _, err := db.ExpireUserCheckoutAttempts(attempt.UserID, attempt.SaleID); err != nil {
+		t.Fatalf("failed to expire checkout attempts: %v", err)
+	}
+
+	_, err := s.ProcessPurchase(t.Context(), attempt.ID, "")
+	if !errors.Is(err, ErrCheckoutCodeExpired) {
+		t.Fatalf("err = %v, want %v", err, ErrCheckoutCodeExpired)
+	}
+	item, err := db.GetItemByID(items[0].ID)
+	if err != nil {
+		t.Fatalf("failed to read item: %v", err)
+	}
+	if item.IsSold {
+		t.Fatal("item was sold to a released code")
+	}
+}
+
+func TestReleaseUserReservationsFreesCheckoutSlots(t *testing.T) {
+	cfg := integrationConfig()
+	cfg.CheckoutQueueCapacity = 10
+	s, db, rdb := integrationService(t, cfg)
+	sale, items := seedIntegrationSale(t, db, 1)
+
+	attempt := newTestAttempt(t, "user-1", items[0])
+	if err := db.CreateCheckoutAttempt(attempt); err != nil {
+		t.Fatalf("failed to create checkout attempt: %v", err)
+	}
+	if err := rdb.OccupyCheckoutSlot(t.Context(), attempt); err != nil {
+		t.Fatalf("failed to occupy checkout slot: %v", err)
+	}
+
+	if _, n, err := s.ReleaseUserReservations(t.Context(), attempt.UserID); err != nil || n != 1 {
+		t.Fatalf("ReleaseUserReservations = %d, %v; want 1 code released", n, err)
+	}
+	// The key carries this test's prefix, which the service does not expose;
+	// the code itself is unique across tests.
+	keys, err := rdb.Client.Keys(t.Context(), fmt.Sprintf("test:*:sale:{%d}:checkout_slots", sale.ID)).Result()
+	if err != nil {
+		t.Fatalf("failed to list checkout slot keys: %v", err)
+	}
+	for _, key := range keys {
+		err := rdb.Client.ZScore(t.Context(), key, "code:"+attempt.ID).Err()
+		if !errors.Is(err, redis.Nil) {
+			t.Fatalf("released code still holds a checkout slot in %s (err = %v)", key, err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// LeaderboardSize is how many top buyers a leaderboard lists.
+const LeaderboardSize = 10
+
+func leaderboardCacheName(saleID int64) string {
+	return fmt.Sprintf("sale:%d:leaderboard", saleID)
+}
+
+// RefreshLeaderboard recomputes the active sale's leaderboard into Redis,
+// so polling clients are served without touching the database. The entry
+// outlives two refreshes, leaving room for one missed tick.
+func (s *SaleService) RefreshLeaderboard(ctx context.Context) error {
+	if s.cfg().LeaderboardRefreshInterval <= 0 {
+		return nil
+	}
+	sale, err := s.readStore.GetActiveSale()
+	if err != nil || sale == nil {
+		return err
+	}
+
+	entries, err := s.loadLeaderboard(ctx, sale.ID)
+	if err != nil {
+		return err
+	}
+	s.setCached(ctx, leaderboardCacheName(sale.ID), entries, 2*s.cfg().LeaderboardRefreshInterval)
+	return nil
+}
+
+// GetLeaderboard returns the sale's top buyers with anonymized IDs. The
+// active sale's leaderboard is read from the cache RefreshLeaderboard keeps,
+// up to LeaderboardRefreshInterval old; other sales, or a cache miss, fall
+// back to a live query.
+func (s *SaleService) GetLeaderboard(ctx context.Context, saleID int64) (_ []models.LeaderboardEntry, err error) {
+	ctx, span := startSpan(ctx, "SaleService.GetLeaderboard", attribute.Int64("sale_id", saleID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	var entries []models.LeaderboardEntry
+	if s.cfg().LeaderboardRefreshInterval > 0 && s.getCached(ctx, leaderboardCacheName(saleID), &entries) {
+		return entries, nil
+	}
+	return s.loadLeaderboard(ctx, saleID)
+}
+
+func (s *SaleService) loadLeaderboard(ctx context.Context, saleID int64) ([]models.LeaderboardEntry, error) {
+	entries, err := traceStore(ctx, "GetSaleLeaderboard", func() ([]models.LeaderboardEntry, error) {
+		return s.readStore.GetSaleLeaderboard(saleID, LeaderboardSize)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range entries {
+		entries[i].Buyer = s.anonymizeUserID(entries[i].Buyer)
+	}
+	return entries, nil
+}
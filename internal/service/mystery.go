@@ -0,0 +1,186 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/store"
+	"notcoin_contest/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// maxMysteryPopAttempts bounds how many pooled items are skipped because
+// they were sold or disabled after the pool was seeded.
+const maxMysteryPopAttempts = 5
+
+// MysteryMode reports whether checkouts are assigned a random item instead
+// of the one the client picks.
+func (s *SaleService) MysteryMode() bool {
+	return s.cfg().MysteryMode
+}
+
+// ProcessMysteryCheckout reserves a random available item for the user. Items
+// are drawn from a per-sale Redis set with SPOP, so no two checkouts are
+// given the same item. If the pool cannot be used because Redis is failing,
+// the item is claimed from the database instead.
+func (s *SaleService) ProcessMysteryCheckout(ctx context.Context, userID string, meta CheckoutMeta) (_ *CheckoutResult, err error) {
+	defer mapUnavailable(&err)
+	ctx, span := startSpan(ctx, "SaleService.ProcessMysteryCheckout")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	activeSale, err := s.saleForCheckout(ctx)
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("sale_id", activeSale.ID))
+
+	purchased, err := s.userPurchaseCount(ctx, userID, activeSale.ID)
+	if err != nil {
+		return nil, err
+	}
+	result, err := s.userAllowance(ctx, userID, activeSale, purchased)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.admitCheckout(ctx, activeSale, userID); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.releaseCheckoutSlot(ctx, activeSale.ID, userID)
+		}
+	}()
+
+	if err := s.ensureMysteryPool(ctx, activeSale); err != nil {
+		s.logger.Printf("Warning: mystery pool for sale ID %d unavailable, claiming from the database: %v\n", activeSale.ID, err)
+		return s.claimMysteryItem(ctx, activeSale, userID, result, meta)
+	}
+
+	for range maxMysteryPopAttempts {
+		itemID, ok, err := s.redisStore.PopAvailableItem(ctx, activeSale.ID)
+		if err != nil {
+			s.logger.Printf("Warning: mystery pool for sale ID %d unavailable, claiming from the database: %v\n", activeSale.ID, err)
+			return s.claimMysteryItem(ctx, activeSale, userID, result, meta)
+		}
+		if !ok {
+			return nil, ErrSaleLimitReached
+		}
+
+		item, err := traceStore(ctx, "GetItemForCheckout", func() (*models.Item, error) {
+			return s.dbStore.GetItemForCheckout(itemID, activeSale.ID)
+		})
+		if err != nil {
+			s.returnMysteryItem(ctx, activeSale.ID, itemID)
+			return nil, fmt.Errorf("failed to get item details: %w", err)
+		}
+		if item == nil {
+			// Sold or disabled since the pool was seeded; drop it.
+			continue
+		}
+
+		span.SetAttributes(attribute.Int64("item_id", itemID))
+		result.ItemID = itemID
+		if err := s.issueCheckoutCode(ctx, activeSale, userID, result, meta); err != nil {
+			s.returnMysteryItem(ctx, activeSale.ID, itemID)
+			return nil, err
+		}
+		return result, nil
+	}
+	return nil, ErrItemNotFoundOrSold
+}
+
+// claimMysteryItem reserves a random item straight from the database, which
+// picks the item and records the checkout attempt in one transaction.
+func (s *SaleService) claimMysteryItem(ctx context.Context, sale *models.Sale, userID string, result *CheckoutResult, meta CheckoutMeta) (*CheckoutResult, error) {
+	var noItem bool
+	claim := func(attempt *models.CheckoutAttempt) error {
+		_, err := s.dbStore.ClaimRandomUnsoldItem(attempt)
+		noItem = errors.Is(err, store.ErrDBNoItemAvailable)
+		return err
+	}
+	if err := s.issueCheckoutCodeWith(ctx, sale, userID, result, meta, claim); err != nil {
+		if noItem {
+			return nil, ErrSaleLimitReached
+		}
+		return nil, err
+	}
+	return result, nil
+}
+
+// ensureMysteryPool seeds the sale's pool from the database if nothing has
+// seeded it yet, e.g. after mystery mode was switched on mid-sale.
+func (s *SaleService) ensureMysteryPool(ctx context.Context, sale *models.Sale) error {
+	seeded, err := s.redisStore.IsAvailableItemsSeeded(ctx, sale.ID)
+	if err != nil || seeded {
+		return err
+	}
+	itemIDs, err := traceStore(ctx, "ListAvailableItemIDs", func() ([]int64, error) {
+		return s.dbStore.ListAvailableItemIDs(sale.ID)
+	})
+	if err != nil {
+		return err
+	}
+	return s.seedMysteryPool(ctx, sale, itemIDs)
+}
+
+func (s *SaleService) seedMysteryPool(ctx context.Context, sale *models.Sale, itemIDs []int64) error {
+	ttl := time.Until(sale.EndTime)
+	if ttl <= 0 {
+		return nil
+	}
+	seeded, err := s.redisStore.SeedAvailableItems(ctx, sale.ID, itemIDs, ttl)
+	if err != nil {
+		return err
+	}
+	if seeded {
+		s.logger.Printf("Seeded mystery pool for sale ID %d with %d items.", sale.ID, len(itemIDs))
+	}
+	return nil
+}
+
+func (s *SaleService) returnMysteryItem(ctx context.Context, saleID, itemID int64) {
+	if err := s.redisStore.ReturnAvailableItem(ctx, saleID, itemID); err != nil {
+		s.logger.Printf("Warning: failed to return item %d to the mystery pool of sale %d: %v\n", itemID, saleID, err)
+	}
+}
+
+// ReturnExpiredMysteryItems puts the items of the active sale whose checkout
+// codes expired unused back into its mystery pool. Popping takes an item out
+// of the pool for good, so without this an abandoned mystery checkout would
+// hide its item for the rest of the sale. An item popped by a checkout whose
+// code is not recorded yet can be returned too; the purchase still sells it
+// only once.
+func (s *SaleService) ReturnExpiredMysteryItems(ctx context.Context) error {
+	if !s.cfg().MysteryMode {
+		return nil
+	}
+	sale, err := s.dbStore.GetActiveSale()
+	if err != nil || sale == nil {
+		return err
+	}
+	ttl := time.Until(sale.EndTime)
+	if ttl <= 0 {
+		return nil
+	}
+	seeded, err := s.redisStore.IsAvailableItemsSeeded(ctx, sale.ID)
+	if err != nil || !seeded {
+		return err
+	}
+
+	// Looking back two reaper intervals lets one missed tick go by without
+	// losing items.
+	itemIDs, err := s.dbStore.ListExpiredCheckoutItemIDs(sale.ID, 2*s.cfg().SaleReaperInterval)
+	if err != nil || len(itemIDs) == 0 {
+		return err
+	}
+	if err := s.redisStore.ReturnAvailableItems(ctx, sale.ID, itemIDs, ttl); err != nil {
+		return err
+	}
+	s.logger.Printf("Returned %d items of expired checkouts to the mystery pool of sale ID %d.", len(itemIDs), sale.ID)
+	return nil
+}
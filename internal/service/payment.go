@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/telemetry"
+)
+
+// PaymentRequest describes the payment a purchase claims to have made.
+type PaymentRequest struct {
+	Reference    string
+	CheckoutCode string
+	UserID       string
+	ItemID       int64
+	AmountCents  int
+	Currency     string
+}
+
+// PaymentVerifier confirms with a payment provider that a payment was made.
+// VerifyPayment returns ErrPaymentNotVerified when the provider rejects the
+// payment and any other error when it cannot be reached.
+type PaymentVerifier interface {
+	VerifyPayment(ctx context.Context, req PaymentRequest) error
+}
+
+// NoopPaymentVerifier accepts every payment. It is the default, since the
+// contest has no payment provider.
+type NoopPaymentVerifier struct{}
+
+func (NoopPaymentVerifier) VerifyPayment(context.Context, PaymentRequest) error {
+	return nil
+}
+
+// SetPaymentVerifier replaces the verifier used by ProcessPurchase.
+func (s *SaleService) SetPaymentVerifier(v PaymentVerifier) {
+	s.payments = v
+}
+
+// verifyPayment checks the purchase's payment reference against the item's
+// price with the configured verifier. A reference is required when
+// PaymentReferenceRequired is set or a verifier other than the default
+// NoopPaymentVerifier is installed.
+func (s *SaleService) verifyPayment(ctx context.Context, attempt *models.CheckoutAttempt, reference string) (err error) {
+	_, noop := s.payments.(NoopPaymentVerifier)
+	if reference == "" && (s.cfg().PaymentReferenceRequired || !noop) {
+		return ErrPaymentReferenceRequired
+	}
+
+	ctx, span := startSpan(ctx, "SaleService.verifyPayment")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	item, err := traceStore(ctx, "GetItemByID", func() (*models.Item, error) {
+		return s.dbStore.GetItemByID(attempt.ItemID)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get item details: %w", err)
+	}
+	if item == nil {
+		return ErrItemNotFoundOrSold
+	}
+
+	return s.payments.VerifyPayment(ctx, PaymentRequest{
+		Reference:    reference,
+		CheckoutCode: attempt.ID,
+		UserID:       attempt.UserID,
+		ItemID:       item.ID,
+		AmountCents:  item.PriceCents,
+		Currency:     item.Currency,
+	})
+}
@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/store"
+	"notcoin_contest/internal/telemetry"
+)
+
+// saleWarmerLock elects the one instance that prepares the next sale.
+const saleWarmerLock = "sale_warmer"
+
+// PrepareNextSale creates the next sale and its items ahead of the cycle
+// boundary, inactive, so ManageHourlySaleCycle only has to switch it on. Only
+// the instance holding the warmer lock does the work; the others return
+// without error.
+func (s *SaleService) PrepareNextSale(ctx context.Context) (err error) {
+	ctx, span := startSpan(ctx, "SaleService.PrepareNextSale")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	acquired, err := s.redisStore.AcquireLock(ctx, saleWarmerLock, s.instanceID, s.cfg().SalePrewarmLead)
+	if err != nil {
+		return err
+	}
+	if !acquired {
+		s.logger.Println("Sale warmer: another instance holds the warmer lock; skipping.")
+		return nil
+	}
+	defer func() {
+		if err := s.redisStore.ReleaseLock(context.Background(), saleWarmerLock, s.instanceID); err != nil {
+			s.logger.Printf("Sale warmer: %v", err)
+		}
+	}()
+
+	prepared, err := s.dbStore.GetPreparedSale()
+	if err != nil {
+		return err
+	}
+	if prepared != nil {
+		count, err := s.dbStore.CountItemsForSale(prepared.ID)
+		if err != nil {
+			return err
+		}
+		if count == prepared.TotalItems {
+			s.logger.Printf("Sale warmer: sale ID %d is already prepared.", prepared.ID)
+			return nil
+		}
+		// Left behind by an instance that stopped part-way through.
+		s.logger.Printf("Sale warmer: discarding incomplete prepared sale ID %d (%d of %d items).",
+			prepared.ID, count, prepared.TotalItems)
+		if err := s.dbStore.DeletePreparedSale(prepared.ID); err != nil {
+			return err
+		}
+	}
+
+	// The times are placeholders; activation sets the real ones.
+	sale, err := s.dbStore.CreatePreparedSale(s.newSale(time.Now().Add(s.cfg().SalePrewarmLead)))
+	if errors.Is(err, store.ErrDBPreparedSaleExists) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	items, err := s.createItemsBatch(s.newSaleItems(sale.ID))
+	if err != nil {
+		if deleteErr := s.dbStore.DeletePreparedSale(sale.ID); deleteErr != nil {
+			s.logger.Printf("Sale warmer: additionally failed to delete prepared sale ID %d: %v", sale.ID, deleteErr)
+		}
+		return fmt.Errorf("failed to create items for prepared sale: %w", err)
+	}
+
+	s.logger.Printf("Sale warmer: prepared sale ID %d with %d items.", sale.ID, len(items))
+	return nil
+}
+
+// activatePreparedSale switches on the prepared sale, if one is complete,
+// deactivating the current one in the same transaction. It returns nil when
+// there was nothing to activate.
+func (s *SaleService) activatePreparedSale(ctx context.Context) (*models.Sale, error) {
+	times := s.newSale(time.Now())
+	sale, deactivated, err := s.dbStore.ActivatePreparedSale(times.PreviewStart, times.StartTime, times.EndTime)
+	if err != nil || sale == nil {
+		return nil, err
+	}
+	s.closeOutSales(ctx, deactivated)
+
+	if s.cfg().MysteryMode {
+		if err := s.ensureMysteryPool(ctx, sale); err != nil {
+			s.logger.Printf("Warning: failed to seed mystery pool for sale ID %d: %v", sale.ID, err)
+		}
+	}
+	return sale, nil
+}
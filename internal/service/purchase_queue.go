@@ -0,0 +1,70 @@
+package service
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// purchaseQueue bounds how many purchase transactions run at once. Callers
+// beyond the limit wait in line, up to a timeout; once the line itself is
+// full they are turned away straight away.
+type purchaseQueue struct {
+	slots      chan struct{}
+	waiting    atomic.Int64
+	maxWaiting int64
+	timeout    time.Duration
+}
+
+// newPurchaseQueue returns nil when concurrency is zero, which disables the
+// queue.
+func newPurchaseQueue(concurrency, maxWaiting int, timeout time.Duration) *purchaseQueue {
+	if concurrency <= 0 {
+		return nil
+	}
+	return &purchaseQueue{
+		slots:      make(chan struct{}, concurrency),
+		maxWaiting: int64(maxWaiting),
+		timeout:    timeout,
+	}
+}
+
+// acquire takes a slot, returning ErrPurchaseQueueFull if the line is full or
+// the wait times out. Every successful acquire must be paired with release.
+// A nil queue always succeeds.
+func (q *purchaseQueue) acquire(ctx context.Context) error {
+	if q == nil {
+		return nil
+	}
+
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if q.waiting.Add(1) > q.maxWaiting {
+		q.waiting.Add(-1)
+		return ErrPurchaseQueueFull
+	}
+	defer q.waiting.Add(-1)
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrPurchaseQueueFull
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (q *purchaseQueue) release() {
+	if q == nil {
+		return
+	}
+	<-q.slots
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"notcoin_contest/internal/models"
+)
+
+const (
+	// QueuePollInterval is how often queued clients are asked to retry.
+	QueuePollInterval = 2 * time.Second
+	// queueStaleAfter is how long a queued user may go without retrying
+	// before they lose their place.
+	queueStaleAfter = 5 * QueuePollInterval
+	// checkoutSlotHold is how long an admitted user's slot is held while
+	// their checkout code is issued.
+	checkoutSlotHold = 10 * time.Second
+)
+
+// ErrCheckoutQueued is matched by CheckoutQueuedError.
+var ErrCheckoutQueued = errors.New("sale is at checkout capacity")
+
+// CheckoutQueuedError is returned instead of a checkout code while the sale is
+// at CheckoutQueueCapacity. It matches ErrCheckoutQueued with errors.Is.
+type CheckoutQueuedError struct {
+	// Position is the user's 1-based place in the queue.
+	Position int64
+	// EstimatedWait assumes every code ahead runs until it expires, so it is
+	// an upper bound; purchases free slots sooner.
+	EstimatedWait time.Duration
+}
+
+func (e *CheckoutQueuedError) Error() string {
+	return fmt.Sprintf("%s; queue position %d", ErrCheckoutQueued, e.Position)
+}
+
+func (e *CheckoutQueuedError) Is(target error) bool {
+	return target == ErrCheckoutQueued
+}
+
+// admitCheckout takes a checkout slot of the sale for the user, or returns a
+// *CheckoutQueuedError with their place in the queue when none is free.
+// Without a capacity everyone is admitted, and so is everyone while Redis
+// is unreachable.
+func (s *SaleService) admitCheckout(ctx context.Context, sale *models.Sale, userID string) error {
+	capacity := s.cfg().CheckoutQueueCapacity
+	if capacity == 0 {
+		return nil
+	}
+
+	ttl := time.Until(sale.EndTime) + s.cfg().CodeTTLExpiry
+	position, err := s.redisStore.AdmitCheckout(ctx, sale.ID, userID, capacity, checkoutSlotHold, queueStaleAfter, ttl)
+	if err != nil {
+		s.logger.Printf("Warning: failed to check checkout capacity for sale %d, admitting user %s: %v\n", sale.ID, userID, err)
+		return nil
+	}
+	if position == 0 {
+		return nil
+	}
+
+	rounds := (position + int64(capacity) - 1) / int64(capacity)
+	return &CheckoutQueuedError{
+		Position:      position,
+		EstimatedWait: time.Duration(rounds) * s.cfg().CodeTTLExpiry,
+	}
+}
+
+// releaseCheckoutSlot gives back the slot admitCheckout took for a checkout
+// that then failed.
+func (s *SaleService) releaseCheckoutSlot(ctx context.Context, saleID int64, userID string) {
+	if s.cfg().CheckoutQueueCapacity == 0 {
+		return
+	}
+	if err := s.redisStore.ReleaseUserCheckoutSlot(ctx, saleID, userID); err != nil {
+		s.logger.Printf("Warning: failed to release checkout slot of user %s in sale %d: %v\n", userID, saleID, err)
+	}
+}
+
+// occupyCheckoutSlot moves the user's slot onto the code just issued, so it
+// stays held until the code is used or expires.
+func (s *SaleService) occupyCheckoutSlot(ctx context.Context, attempt *models.CheckoutAttempt) {
+	if s.cfg().CheckoutQueueCapacity == 0 {
+		return
+	}
+	if err := s.redisStore.OccupyCheckoutSlot(ctx, attempt); err != nil {
+		s.logger.Printf("Warning: failed to record checkout slot of code %s: %v\n", attempt.ID, err)
+	}
+}
+
+// freeCheckoutSlot releases the slot held by a code that has been used.
+func (s *SaleService) freeCheckoutSlot(ctx context.Context, attempt *models.CheckoutAttempt) {
+	if s.cfg().CheckoutQueueCapacity == 0 {
+		return
+	}
+	if err := s.redisStore.ReleaseCodeCheckoutSlot(ctx, attempt.SaleID, attempt.ID); err != nil {
+		s.logger.Printf("Warning: failed to release checkout slot of code %s: %v\n", attempt.ID, err)
+	}
+}
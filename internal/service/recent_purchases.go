@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// MaxRecentPurchases caps how many purchases the ticker returns.
+	MaxRecentPurchases = 50
+	// recentPurchasesCacheTTL keeps polling clients off the database while
+	// staying fresh enough for a live ticker.
+	recentPurchasesCacheTTL = 2 * time.Second
+	// buyerHashLength is how many hex characters of the keyed user ID hash are
+	// shown, enough to tell buyers apart in a ticker.
+	buyerHashLength = 8
+)
+
+// GetRecentPurchases returns the latest purchases of a sale with anonymized
+// buyers, served from a short-lived Redis cache when possible. Cache
+// failures fall back to the database.
+func (s *SaleService) GetRecentPurchases(ctx context.Context, saleID int64, limit int) (_ []models.RecentPurchase, err error) {
+	ctx, span := startSpan(ctx, "SaleService.GetRecentPurchases", attribute.Int64("sale_id", saleID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	limit = min(limit, MaxRecentPurchases)
+
+	cacheName := fmt.Sprintf("sale:%d:recent:%d", saleID, limit)
+	var purchases []models.RecentPurchase
+	if s.getCached(ctx, cacheName, &purchases) {
+		return purchases, nil
+	}
+
+	purchases, err = traceStore(ctx, "GetRecentPurchases", func() ([]models.RecentPurchase, error) {
+		return s.readStore.GetRecentPurchases(saleID, limit)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range purchases {
+		purchases[i].Buyer = s.anonymizeUserID(purchases[i].Buyer)
+	}
+
+	s.setCached(ctx, cacheName, purchases, recentPurchasesCacheTTL)
+	return purchases, nil
+}
+
+// anonymizeUserID returns a short, stable HMAC of userID so a ticker can
+// show repeat buyers without revealing who they are. Keying it with a server
+// secret keeps a known user ID from being hashed and matched by anyone else.
+func (s *SaleService) anonymizeUserID(userID string) string {
+	mac := hmac.New(sha256.New, s.anonymizeKey)
+	mac.Write([]byte(userID))
+	return hex.EncodeToString(mac.Sum(nil))[:buyerHashLength]
+}
@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReconcileSoldItems brings the active sale's inventory counters back in
+// line with its items: sold_items is recomputed from the sold items, and the
+// Redis sold-out flag is set or cleared to match what is left to buy. Any
+// drift found is logged.
+func (s *SaleService) ReconcileSoldItems(ctx context.Context) error {
+	sale, err := s.dbStore.GetActiveSale()
+	if err != nil || sale == nil {
+		return err
+	}
+
+	recorded, counted, err := s.dbStore.ReconcileSoldItems(sale.ID)
+	if err != nil {
+		return err
+	}
+	if recorded != counted {
+		s.logger.Printf("Warning: sale ID %d recorded %d sold items but %d are sold; corrected sold_items.",
+			sale.ID, recorded, counted)
+	}
+
+	available, err := s.dbStore.HasAvailableItems(sale.ID)
+	if err != nil {
+		return err
+	}
+	soldOut, err := s.redisStore.IsSaleSoldOut(ctx, sale.ID)
+	if err != nil {
+		return err
+	}
+	switch {
+	case soldOut && available:
+		s.logger.Printf("Warning: sale ID %d was flagged sold out but still has items; clearing the flag.", sale.ID)
+		if err := s.redisStore.ClearSaleSoldOut(ctx, sale.ID); err != nil {
+			return fmt.Errorf("failed to clear sold-out flag: %w", err)
+		}
+	case !soldOut && !available:
+		s.logger.Printf("Sale ID %d has nothing left to buy; setting the sold-out flag.", sale.ID)
+		s.markSaleSoldOut(ctx, sale)
+	}
+	return nil
+}
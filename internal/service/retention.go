@@ -0,0 +1,30 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"notcoin_contest/internal/telemetry"
+)
+
+// retentionBatchSales caps how many sales one retention pass purges, so a
+// first run over a long history does not hold the reaper up.
+const retentionBatchSales = 10
+
+// PurgeRetiredItems deletes the items and checkout attempts of sales that
+// ended more than ItemRetention ago. Purchases, user limits and the sales
+// themselves are kept, so purchase history stays queryable. It does nothing
+// when ItemRetention is zero.
+func (s *SaleService) PurgeRetiredItems(ctx context.Context) (err error) {
+	if s.cfg().ItemRetention == 0 {
+		return nil
+	}
+	ctx, span := startSpan(ctx, "SaleService.PurgeRetiredItems")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	saleIDs, items, err := s.dbStore.PurgeRetiredSaleItems(ctx, time.Now().Add(-s.cfg().ItemRetention), retentionBatchSales)
+	if len(saleIDs) > 0 {
+		s.logger.Printf("Retention: deleted %d items of sales %v ended more than %s ago.", items, saleIDs, s.cfg().ItemRetention)
+	}
+	return err
+}
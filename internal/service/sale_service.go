@@ -4,54 +4,151 @@ import (
 	"context"
 	cRand "crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"math/rand"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"notcoin_contest/internal/config"
 	"notcoin_contest/internal/models"
 	"notcoin_contest/internal/store"
-)
+	"notcoin_contest/internal/telemetry"
 
-const (
-	itemsPerSale = 10000
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type SaleService struct {
-	dbStore    *store.DBStore
+	dbStore *store.DBStore
+	// readStore serves read-only endpoints, from a replica when one is
+	// configured. Anything on the checkout or purchase path uses dbStore.
+	readStore  *store.DBStore
 	redisStore *store.RedisStore
-	config     *config.Config
-	logger     *log.Logger
+	// config is swapped whole by ReloadConfig; read it through cfg.
+	config atomic.Pointer[config.Config]
+	logger *log.Logger
+	// instanceID identifies this process as the owner of Redis locks.
+	instanceID string
+	// purchases limits concurrent purchase transactions; nil when disabled.
+	purchases *purchaseQueue
+	payments  PaymentVerifier
+
+	// lastCycleAt holds the UnixNano time the scheduler last ran a sale
+	// cycle, or zero before the first run.
+	lastCycleAt atomic.Int64
+	// lastActiveSale is the last active sale this process read, served
+	// while the database is unreachable.
+	lastActiveSale atomic.Pointer[models.Sale]
+	// anonymizeKey keys anonymizeUserID: ANONYMIZE_SECRET, or a random key
+	// for this process when it is unset.
+	anonymizeKey []byte
+
+	// allowlistActive records whether the allowlist had members at the last
+	// successful user list check, so checkUserAccess can fail closed while
+	// Redis is unreachable.
+	allowlistActive atomic.Bool
 }
 
-func NewSaleService(logger *log.Logger, db *store.DBStore, redis *store.RedisStore, cfg *config.Config) *SaleService {
-	return &SaleService{
+// NewSaleService builds the service. replica may be nil, in which case reads
+// go to the primary db.
+func NewSaleService(logger *log.Logger, db, replica *store.DBStore, redis *store.RedisStore, cfg *config.Config) *SaleService {
+	if replica == nil {
+		replica = db
+	}
+	s := &SaleService{
 		dbStore:    db,
+		readStore:  replica,
 		redisStore: redis,
-		config:     cfg,
 		logger:     logger,
+		instanceID: instanceID(),
+		purchases:  newPurchaseQueue(cfg.PurchaseConcurrency, cfg.PurchaseQueueSize, cfg.PurchaseQueueTimeout),
+		payments:   NoopPaymentVerifier{},
+	}
+	s.config.Store(cfg)
+	s.anonymizeKey = []byte(cfg.AnonymizeSecret)
+	if len(s.anonymizeKey) == 0 {
+		logger.Println("Warning: ANONYMIZE_SECRET is not set, anonymized buyer IDs use a random key and differ between instances and restarts")
+		s.anonymizeKey = make([]byte, 32)
+		cRand.Read(s.anonymizeKey)
+	}
+	return s
+}
+
+// cfg returns the current configuration. Callers needing several settings
+// to agree should read it once.
+func (s *SaleService) cfg() *config.Config {
+	return s.config.Load()
+}
+
+// ReloadConfig applies the runtime-tunable settings of next, such as user
+// limits and rate limits, and returns the names of those that changed. The
+// names of other settings that differ are returned as ignored; they take a
+// restart.
+func (s *SaleService) ReloadConfig(next *config.Config) (applied, ignored []string) {
+	reloaded, applied, ignored := s.cfg().Reload(next)
+	s.config.Store(reloaded)
+	return applied, ignored
+}
+
+func instanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
 	}
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
 }
 
-func (s *SaleService) ManageHourlySaleCycle(ctx context.Context) error {
+func (s *SaleService) ManageHourlySaleCycle(ctx context.Context) (err error) {
+	_, span := startSpan(ctx, "SaleService.ManageHourlySaleCycle")
+	defer func() { telemetry.EndSpan(span, err) }()
+
 	s.logger.Println("Starting new hourly sale cycle...")
+	s.lastCycleAt.Store(time.Now().UnixNano())
 
-	s.logger.Println("Deactivating all previously active sales...")
-	if err := s.dbStore.DeactivateAllActiveSales(); err != nil {
-		s.logger.Printf("Error deactivating active sales: %v", err)
-	} else {
-		s.logger.Println("Successfully deactivated all previously active sales.")
+	covering, err := s.coveringActiveSale()
+	if err != nil {
+		s.logger.Printf("Error checking for an active sale covering this cycle: %v", err)
+		return fmt.Errorf("failed to check for an active sale: %w", err)
+	}
+	if covering != nil {
+		s.logger.Printf("Sale ID %d opened this cycle and is still active; skipped creating a new sale.", covering.ID)
+		return nil
+	}
+
+	if s.cfg().SalePrewarmLead > 0 {
+		sale, err := s.activatePreparedSale(ctx)
+		if err != nil {
+			s.logger.Printf("Error activating prepared sale, creating one instead: %v", err)
+		} else if sale != nil {
+			s.logger.Printf("Activated prepared sale ID %d. Sale active from %s to %s.",
+				sale.ID, sale.StartTime.Format(time.RFC3339), sale.EndTime.Format(time.RFC3339))
+			return nil
+		} else {
+			s.logger.Println("No prepared sale is ready; creating one now.")
+		}
 	}
 
-	s.logger.Println("Creating new sale and items...")
-	sale, items, err := s.CreateNewSaleAndItems()
+	s.logger.Println("Replacing previously active sales with a new sale and items...")
+	sale, items, err := s.createSaleAndItems(func(sale *models.Sale) (*models.Sale, error) {
+		created, deactivated, err := s.dbStore.ReplaceActiveSale(sale)
+		if err == nil {
+			s.closeOutSales(ctx, deactivated)
+		}
+		return created, err
+	})
 	if err != nil {
 		s.logger.Printf("Error creating new sale and items: %v", err)
 		return fmt.Errorf("failed to create new sale and items: %w", err)
 	}
+	if items == nil {
+		s.logger.Printf("Sale ID %d is already active; skipped creating a new sale.", sale.ID)
+		return nil
+	}
 	s.logger.Printf("Successfully created new sale ID %d with %d items. Sale active from %s to %s.",
 		sale.ID, len(items), sale.StartTime.Format(time.RFC3339), sale.EndTime.Format(time.RFC3339))
 
@@ -59,32 +156,176 @@ func (s *SaleService) ManageHourlySaleCycle(ctx context.Context) error {
 	return nil
 }
 
-func (s *SaleService) CreateNewSaleAndItems() (*models.Sale, []models.Item, error) {
-	now := time.Now()
-	sale := &models.Sale{
-		StartTime:  now,
-		EndTime:    now.Add(s.config.SaleDuration),
-		TotalItems: itemsPerSale,
-		SoldItems:  0,
-		IsActive:   true,
+// coveringSaleSlack is how early a cycle may run, by the clock of the
+// instance running it, and still replace the sale the previous cycle opened.
+const coveringSaleSlack = time.Minute
+
+// coveringActiveSale returns the active sale if a cycle opened it less than
+// a cycle interval ago, as when another instance already ran this cycle or
+// this instance restarted mid-sale, and nil otherwise.
+func (s *SaleService) coveringActiveSale() (*models.Sale, error) {
+	sale, err := s.dbStore.GetActiveSale()
+	if err != nil || sale == nil {
+		return nil, err
 	}
+	openedAt := sale.StartTime
+	if sale.PreviewStart != nil {
+		openedAt = *sale.PreviewStart
+	}
+	slack := min(coveringSaleSlack, s.cfg().SaleCycleInterval/10)
+	if time.Since(openedAt) < s.cfg().SaleCycleInterval-slack {
+		return sale, nil
+	}
+	return nil, nil
+}
 
-	createdSale, err := s.dbStore.CreateSale(sale)
+// ReapEndedSales deactivates sales whose end time has passed so that
+// is_active stays authoritative between hourly cycles.
+func (s *SaleService) ReapEndedSales(ctx context.Context) error {
+	saleIDs, err := s.dbStore.DeactivateEndedSales()
 	if err != nil {
-		return nil, nil, fmt.Errorf("failed to create sale in DB: %w", err)
+		return err
 	}
+	for _, id := range saleIDs {
+		s.logger.Printf("Reaper: deactivated ended sale ID %d.", id)
+	}
+	s.closeOutSales(ctx, saleIDs)
+	return nil
+}
 
-	items := make([]models.Item, 0, itemsPerSale)
-	for i := 0; i < itemsPerSale; i++ {
-		items = append(items, models.Item{
-			SaleID:   createdSale.ID,
-			Name:     fmt.Sprintf("Awesome Item #%d-%d", createdSale.ID, i+1),
-			ImageURL: fmt.Sprintf("https://example.com/image/%d/%d.png", createdSale.ID, rand.Intn(1000)),
-			IsSold:   false,
-		})
+// DeactivateAllSales switches every active sale off at once, for
+// emergencies, and clears their Redis flags and mystery pools. It returns the
+// IDs of the sales it deactivated. The next cycle creates a sale as usual.
+func (s *SaleService) DeactivateAllSales(ctx context.Context) (_ []int64, err error) {
+	ctx, span := startSpan(ctx, "SaleService.DeactivateAllSales")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	saleIDs, err := traceStore(ctx, "DeactivateAllActiveSales", s.dbStore.DeactivateAllActiveSales)
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range saleIDs {
+		if err := s.redisStore.ClearSaleFlags(ctx, id); err != nil {
+			s.logger.Printf("Warning: failed to clear Redis state of deactivated sale ID %d: %v", id, err)
+		}
+	}
+	s.closeOutSales(ctx, saleIDs)
+	return saleIDs, nil
+}
+
+// emptySaleGracePeriod is how long a new sale may go without items before
+// DeactivateEmptyActiveSale treats it as broken, leaving time for an item
+// batch still being inserted by another instance.
+const emptySaleGracePeriod = 2 * time.Minute
+
+// DeactivateEmptyActiveSale deactivates the active sale if it has no items,
+// as happens when item creation failed and the sale could not be switched
+// off. Checkouts against such a sale could only ever fail.
+func (s *SaleService) DeactivateEmptyActiveSale(ctx context.Context) error {
+	sale, err := s.dbStore.GetActiveSale()
+	if err != nil || sale == nil {
+		return err
+	}
+	if time.Since(sale.CreatedAt) < emptySaleGracePeriod {
+		return nil
+	}
+
+	count, err := s.dbStore.CountItemsForSale(sale.ID)
+	if err != nil || count > 0 {
+		return err
+	}
+
+	s.logger.Printf("Warning: active sale ID %d has no items; deactivating it.", sale.ID)
+	if err := s.dbStore.DeactivateSaleByID(sale.ID); err != nil {
+		return err
+	}
+	s.forgetActiveSale(ctx, sale.ID)
+	return nil
+}
+
+// DiscardSale deletes a sale and everything recorded for it, whatever its
+// state, and clears its Redis state. It exists for the self-test, which must
+// leave nothing of its sale behind; operators purge sales with PurgeSale.
+func (s *SaleService) DiscardSale(ctx context.Context, saleID int64) error {
+	if err := s.dbStore.DeleteSale(saleID); err != nil {
+		return err
+	}
+	if err := s.redisStore.ClearSaleFlags(ctx, saleID); err != nil {
+		s.logger.Printf("Warning: failed to clear Redis state of discarded sale ID %d: %v", saleID, err)
+	}
+	s.forgetActiveSale(ctx, saleID)
+	return nil
+}
+
+// closeOutSales wraps up each sale that just ended: it forgets it as the last
+// known active sale, cancels checkout codes still open against it, records
+// what it left unsold, and logs a post-mortem.
+func (s *SaleService) closeOutSales(ctx context.Context, saleIDs []int64) {
+	for _, id := range saleIDs {
+		s.forgetActiveSale(ctx, id)
+		if orphaned, err := s.dbStore.MarkOrphanedAttempts(id); err != nil {
+			s.logger.Printf("Error cancelling open checkout codes of sale ID %d: %v", id, err)
+		} else if orphaned > 0 {
+			s.logger.Printf("Cancelled %d open checkout codes of ended sale ID %d.", orphaned, id)
+		}
+
+		if unsold, err := s.dbStore.SnapshotUnsoldItems(id); err != nil {
+			s.logger.Printf("Error recording unsold items for sale ID %d: %v", id, err)
+		} else {
+			s.logger.Printf("Sale ID %d ended with %d unsold items.", id, unsold)
+		}
+
+		summary, err := s.dbStore.GetSaleSummary(id)
+		if err != nil {
+			s.logger.Printf("Error computing summary for sale ID %d: %v", id, err)
+			continue
+		}
+		if summary == nil {
+			continue
+		}
+		s.logger.Printf("Sale summary: sale_id=%d total_items=%d sold_items=%d sell_through=%.1f%% unique_buyers=%d peak_checkouts_per_minute=%d",
+			summary.SaleID, summary.TotalItems, summary.SoldItems, summary.SellThroughPercent,
+			summary.UniqueBuyers, summary.PeakCheckoutsPerMinute)
 	}
+}
 
-	createdItems, err := s.dbStore.CreateItemsBatch(items)
+// CreateNewSaleAndItems creates a sale with its items. If another sale is
+// already active, for example because a second scheduler got there first,
+// that sale is returned with nil items instead.
+func (s *SaleService) CreateNewSaleAndItems() (*models.Sale, []models.Item, error) {
+	existing, err := s.dbStore.GetActiveSale()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to check for an active sale: %w", err)
+	}
+	if existing != nil {
+		return existing, nil, nil
+	}
+	return s.createSaleAndItems(s.dbStore.CreateSale)
+}
+
+// createSaleAndItems inserts a new sale with create, then its items. When
+// create fails because a concurrent creator's sale is already active, that
+// sale is returned with nil items instead.
+func (s *SaleService) createSaleAndItems(create func(*models.Sale) (*models.Sale, error)) (*models.Sale, []models.Item, error) {
+	sale := s.newSale(time.Now())
+	createdSale, err := create(sale)
+	if errors.Is(err, store.ErrDBActiveSaleExists) {
+		// Lost the race to a concurrent creator; the winner's sale is active.
+		existing, err := s.dbStore.GetActiveSale()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to load the concurrently created sale: %w", err)
+		}
+		if existing == nil {
+			return nil, nil, fmt.Errorf("failed to create sale in DB: %w", store.ErrDBActiveSaleExists)
+		}
+		return existing, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create sale in DB: %w", err)
+	}
+
+	items := s.newSaleItems(createdSale.ID)
+	createdItems, err := s.createItemsBatch(items)
 	if err != nil {
 		s.logger.Printf("Failed to create items batch for sale ID %d: %v", createdSale.ID, err)
 		if deactivateErr := s.dbStore.DeactivateSaleByID(createdSale.ID); deactivateErr != nil {
@@ -93,155 +334,1341 @@ func (s *SaleService) CreateNewSaleAndItems() (*models.Sale, []models.Item, erro
 		return createdSale, nil, fmt.Errorf("failed to create items in DB: %w", err)
 	}
 
+	if s.cfg().MysteryMode {
+		itemIDs := make([]int64, len(createdItems))
+		for i, item := range createdItems {
+			itemIDs[i] = item.ID
+		}
+		if err := s.seedMysteryPool(context.Background(), createdSale, itemIDs); err != nil {
+			s.logger.Printf("Warning: failed to seed mystery pool for sale ID %d: %v", createdSale.ID, err)
+		}
+	}
+
 	return createdSale, createdItems, nil
 }
 
-func (s *SaleService) GetCurrentActiveSale() (*models.Sale, error) {
-	return s.dbStore.GetActiveSale()
+// itemBatchRetryDelay is the pause before the first retry of an item batch,
+// doubled for each further retry.
+const itemBatchRetryDelay = 500 * time.Millisecond
+
+// createItemsBatch checks a sale's items' image URLs and inserts them,
+// retrying up to ItemBatchRetries times when the database fails
+// transiently. The batch is one transaction, so a failed attempt leaves
+// nothing behind to clean up.
+func (s *SaleService) createItemsBatch(items []models.Item) ([]models.Item, error) {
+	if err := s.validateItemImages(items); err != nil {
+		return nil, err
+	}
+
+	delay := itemBatchRetryDelay
+	for attempt := 0; ; attempt++ {
+		created, err := s.dbStore.CreateItemsBatch(items)
+		if err == nil || !store.IsTransientError(err) || attempt == s.cfg().ItemBatchRetries {
+			return created, err
+		}
+
+		s.logger.Printf("Retrying item batch for sale ID %d in %s after transient error (retry %d of %d): %v",
+			items[0].SaleID, delay, attempt+1, s.cfg().ItemBatchRetries, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// newSale builds an active sale opening at now from the configured
+// duration, preview lead and overrides. It leaves MaxItemsPerUser unset, so
+// the sale follows MAX_ITEMS_PER_USER as reloaded rather than a copy taken
+// when it opened.
+func (s *SaleService) newSale(now time.Time) *models.Sale {
+	durationSeconds := int(s.cfg().SaleDuration / time.Second)
+	sale := &models.Sale{
+		Title:      s.cfg().SaleTitle,
+		Category:   s.cfg().SaleCategory,
+		StartTime:  now.Add(s.cfg().SalePreviewLead),
+		EndTime:    now.Add(s.cfg().SaleDuration),
+		TotalItems: s.cfg().ItemsPerSale,
+		SoldItems:  0,
+		IsActive:   true,
+
+		DurationSeconds: &durationSeconds,
+	}
+	if s.cfg().SalePreviewLead > 0 {
+		sale.PreviewStart = &now
+	}
+	return sale
+}
+
+func (s *SaleService) newSaleItems(saleID int64) []models.Item {
+	items := make([]models.Item, 0, s.cfg().ItemsPerSale)
+	for i := 0; i < s.cfg().ItemsPerSale; i++ {
+		item := models.Item{
+			SaleID:     saleID,
+			Name:       fmt.Sprintf("Awesome Item #%d-%d", saleID, i+1),
+			ImageURL:   fmt.Sprintf("image/%d/%d.png", saleID, rand.Intn(1000)),
+			PriceCents: s.cfg().ItemPriceCents,
+			Currency:   s.cfg().ItemCurrency,
+			IsSold:     false,
+		}
+		if s.cfg().ItemSKUCount > 0 {
+			item.SKU = fmt.Sprintf("SKU-%d", i%s.cfg().ItemSKUCount+1)
+		}
+		items = append(items, item)
+	}
+	return items
+}
+
+// GetCurrentActiveSale returns the active sale, or nil when there is none.
+// While the database is unreachable it returns the last known active sale
+// and reports degraded.
+func (s *SaleService) GetCurrentActiveSale(ctx context.Context) (_ *models.Sale, degraded bool, err error) {
+	return s.activeSaleOrLastKnown(ctx, s.readStore.GetActiveSale)
 }
 
-const userMaxItemsPerSale = 10
+// NextSaleStart estimates when the scheduler will create the next sale. It is
+// derived from the last cycle the scheduler ran, falling back to when the
+// latest sale opened when no cycle has run in this process, as on instances
+// that lose the scheduler election. Either way the scheduler ticks every
+// SaleCycleInterval from there, so the first tick still ahead is returned.
+func (s *SaleService) NextSaleStart() (time.Time, error) {
+	var cycleAt time.Time
+	if last := s.lastCycleAt.Load(); last != 0 {
+		cycleAt = time.Unix(0, last)
+	} else {
+		sale, err := s.readStore.GetLatestSale()
+		if err != nil {
+			return time.Time{}, err
+		}
+		if sale == nil {
+			return time.Time{}, ErrNextSaleUnknown
+		}
+		cycleAt = sale.StartTime
+		if sale.PreviewStart != nil {
+			cycleAt = *sale.PreviewStart
+		}
+	}
+
+	next := cycleAt.Add(s.cfg().SaleCycleInterval)
+	if now := time.Now(); next.Before(now) {
+		missed := now.Sub(next)/s.cfg().SaleCycleInterval + 1
+		next = next.Add(missed * s.cfg().SaleCycleInterval)
+	}
+	return next, nil
+}
+
+// SaleNotStartedError is returned while the active sale is still in its
+// preview window. It matches ErrSaleNotStarted with errors.Is.
+type SaleNotStartedError struct {
+	OpensAt time.Time
+}
+
+func (e *SaleNotStartedError) Error() string {
+	return fmt.Sprintf("%s; purchases open at %s", ErrSaleNotStarted, e.OpensAt.Format(time.RFC3339))
+}
+
+func (e *SaleNotStartedError) Is(target error) bool {
+	return target == ErrSaleNotStarted
+}
+
+// IsSaleInPreview reports whether items of the sale are visible but not yet
+// purchasable.
+func IsSaleInPreview(sale *models.Sale, now time.Time) bool {
+	return now.Before(sale.StartTime)
+}
+
+// ListItems returns a page of unsold items in the active sale, optionally
+// filtered by a case-insensitive name search. cursor is the ID of the last
+// item of the previous page, or zero.
+func (s *SaleService) ListItems(ctx context.Context, query, sort string, cursor int64, limit, offset int) (_ *models.Sale, _ []models.Item, err error) {
+	ctx, span := startSpan(ctx, "SaleService.ListItems")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	if sort == "" {
+		sort = ItemSortID
+	}
+	if !store.IsItemSort(sort) {
+		return nil, nil, ErrInvalidItemSort
+	}
+
+	// Handlers clamp already; this keeps any caller from loading the whole
+	// inventory in one query.
+	limit = min(limit, s.cfg().MaxPageSize)
+
+	activeSale, degraded, err := s.activeSaleOrLastKnown(ctx, s.readStore.GetActiveSale)
+	if err != nil {
+		if errors.Is(err, ErrDatabaseUnavailable) {
+			return nil, nil, err
+		}
+		return nil, nil, fmt.Errorf("failed to get active sale: %w", err)
+	}
+	if activeSale == nil {
+		return nil, nil, ErrSaleNotActive
+	}
+	span.SetAttributes(attribute.Int64("sale_id", activeSale.ID))
+
+	// Pages are cached as served, so an item sold since may still be listed
+	// for up to ItemsCacheTTL; checkout rejects it as usual. Random pages
+	// are not cached, or every client would get the same shuffle.
+	useCache := s.cfg().ItemsCacheTTL > 0 && sort != ItemSortRandom
+	cacheName := fmt.Sprintf("sale:%d:items:%s:%d:%d:%d:%s", activeSale.ID, sort, cursor, limit, offset, query)
+	var items []models.Item
+	if (useCache || degraded) && s.getCached(ctx, cacheName, &items) {
+		return activeSale, items, nil
+	}
+	if degraded {
+		return nil, nil, ErrDatabaseUnavailable
+	}
+
+	if query == "" {
+		items, err = s.readStore.ListUnsoldItems(activeSale.ID, cursor, limit, offset, sort)
+	} else {
+		items, err = s.readStore.SearchUnsoldItems(activeSale.ID, query, cursor, limit, offset, sort)
+	}
+	if err != nil {
+		if store.IsUnavailableError(err) {
+			return nil, nil, ErrDatabaseUnavailable
+		}
+		return nil, nil, fmt.Errorf("failed to list items: %w", err)
+	}
+	for i := range items {
+		s.resolveImageURL(&items[i])
+	}
+	if useCache {
+		s.setCached(ctx, cacheName, items, s.cfg().ItemsCacheTTL)
+	}
+	return activeSale, items, nil
+}
+
+// MaxAdminItemsPage caps a page of GetItemsForSale and of
+// CheckoutCountsByIP.
+const MaxAdminItemsPage = 1000
+
+// GetItemsForSale pages through a sale's items for the admin item grid, all
+// of them or, with sold set, only the sold or only the unsold ones.
+func (s *SaleService) GetItemsForSale(ctx context.Context, saleID int64, sold *bool, limit, offset int) (_ []models.Item, err error) {
+	ctx, span := startSpan(ctx, "SaleService.GetItemsForSale", attribute.Int64("sale_id", saleID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	sale, err := traceStore(ctx, "GetSaleByID", func() (*models.Sale, error) {
+		return s.readStore.GetSaleByID(saleID)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get sale: %w", err)
+	}
+	if sale == nil {
+		return nil, ErrSaleNotFound
+	}
+
+	items, err := traceStore(ctx, "GetItemsForSale", func() ([]models.Item, error) {
+		return s.readStore.GetItemsForSale(saleID, sold, min(limit, MaxAdminItemsPage), offset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		s.resolveImageURL(&items[i])
+	}
+	return items, nil
+}
+
+// GetSaleItem returns a single item of the given sale, and whether an open
+// checkout code currently reserves it. Only unsold, enabled items are checked
+// for a reservation.
+func (s *SaleService) GetSaleItem(ctx context.Context, saleID, itemID int64) (_ *models.Item, reserved bool, err error) {
+	ctx, span := startSpan(ctx, "SaleService.GetSaleItem",
+		attribute.Int64("sale_id", saleID), attribute.Int64("item_id", itemID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	item, err := traceStore(ctx, "GetItemByID", func() (*models.Item, error) {
+		return s.readStore.GetItemByID(itemID)
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get item: %w", err)
+	}
+	if item == nil || item.SaleID != saleID {
+		return nil, false, ErrItemNotFound
+	}
+	s.resolveImageURL(item)
+	if item.IsSold || item.IsDisabled {
+		return item, false, nil
+	}
+	reserved, err = traceStore(ctx, "ItemHasOpenCheckout", func() (bool, error) {
+		return s.readStore.ItemHasOpenCheckout(itemID)
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to check item reservation: %w", err)
+	}
+	return item, reserved, nil
+}
+
+// resolveImageURL prefixes a stored relative image path with the configured
+// base URL. Absolute URLs, such as those stored by older sales, are kept.
+func (s *SaleService) resolveImageURL(item *models.Item) {
+	if s.cfg().ImageBaseURL == "" || item.ImageURL == "" ||
+		strings.HasPrefix(item.ImageURL, "http://") || strings.HasPrefix(item.ImageURL, "https://") {
+		return
+	}
+	item.ImageURL = strings.TrimSuffix(s.cfg().ImageBaseURL, "/") + "/" + strings.TrimPrefix(item.ImageURL, "/")
+}
+
+// DisableItem withdraws an item from sale. Existing reservations for it are
+// left intact and can still be purchased.
+func (s *SaleService) DisableItem(ctx context.Context, itemID int64) error {
+	found, err := traceStore(ctx, "DisableItem", func() (bool, error) {
+		return s.dbStore.DisableItem(itemID)
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrItemNotFound
+	}
+	s.logger.Printf("Disabled item ID %d.", itemID)
+	return nil
+}
+
+const maxCodeGenerationAttempts = 3
+
+// Item sort orders accepted by ListItems.
+const (
+	ItemSortID     = store.ItemSortID
+	ItemSortName   = store.ItemSortName
+	ItemSortRandom = store.ItemSortRandom
+)
 
 var (
-	ErrSaleNotActive           = errors.New("no active sale at the moment")
-	ErrItemNotFoundOrSold      = errors.New("item not found, not part of active sale, or already sold")
-	ErrUserLimitReached        = errors.New("user has reached the purchase limit for this sale")
-	ErrCheckoutFailed          = errors.New("checkout processing failed")
-	ErrCheckoutCodeInvalid     = errors.New("checkout code is invalid")
-	ErrCheckoutCodeAlreadyUsed = errors.New("checkout code has already been used")
-	ErrCheckoutCodeExpired     = errors.New("checkout code has expired")
-	ErrSaleLimitReached        = errors.New("sale item limit reached")
-	ErrPurchaseFailed          = errors.New("purchase failed")
+	ErrSaleNotActive            = errors.New("no active sale at the moment")
+	ErrSaleNotStarted           = errors.New("sale has not opened for purchases yet")
+	ErrNextSaleUnknown          = errors.New("next sale time is not known yet")
+	ErrSaleNotFound             = errors.New("sale not found")
+	ErrSaleNotFinished          = errors.New("sale is active, prepared or has not ended yet")
+	ErrItemNotFoundOrSold       = errors.New("item not found, not part of active sale, or already sold")
+	ErrItemNotFound             = errors.New("item not found")
+	ErrInvalidItemSort          = errors.New("sort must be one of id, name, random")
+	ErrItemAlreadyPurchased     = errors.New("you have already purchased this item")
+	ErrItemFromInactiveSale     = errors.New("item belongs to a sale that is not active")
+	ErrUserLimitReached         = errors.New("user has reached the purchase limit for this sale")
+	ErrGlobalUserLimitReached   = errors.New("user has reached the purchase limit across all sales")
+	ErrSKULimitReached          = errors.New("user has reached the purchase limit for this item's SKU")
+	ErrTooManyReservations      = errors.New("user holds too many unused checkout codes")
+	ErrUserNotAllowed           = errors.New("user is not allowed to take part in this sale")
+	ErrUserListsUnavailable     = errors.New("user lists cannot be checked right now")
+	ErrUnknownUserList          = errors.New("unknown user list")
+	ErrInvalidReportRange       = errors.New("from must be before to, at most 31 days apart")
+	ErrCheckoutFailed           = errors.New("checkout processing failed")
+	ErrCheckoutCodeInvalid      = errors.New("checkout code is invalid")
+	ErrCheckoutCodeAlreadyUsed  = errors.New("checkout code has already been used")
+	ErrCheckoutCodeExpired      = errors.New("checkout code has expired")
+	ErrSaleLimitReached         = errors.New("sale item limit reached")
+	ErrPurchaseFailed           = errors.New("purchase failed")
+	ErrPurchaseQueueFull        = errors.New("too many purchases in progress, try again shortly")
+	ErrPaymentReferenceRequired = errors.New("payment_reference is required")
+	ErrPurchaseVelocityExceeded = errors.New("too many purchases in a short time")
+	ErrPurchaseTooSoon          = errors.New("purchase submitted too soon after checkout")
+	ErrPaymentNotVerified       = errors.New("payment could not be verified")
+	ErrPaymentReferenceUsed     = errors.New("payment_reference has already been used for a purchase")
+	ErrPurchaseBatchMixedUsers  = errors.New("codes must all belong to the same user")
 )
 
-func generateUniqueID(n int) (string, error) {
+// generateUniqueID returns n random bytes rendered in the given
+// CheckoutCodeEncoding.
+func generateUniqueID(n int, encoding string) (string, error) {
 	bytes := make([]byte, n)
 	if _, err := cRand.Read(bytes); err != nil {
 		return "", err
 	}
-	return hex.EncodeToString(bytes), nil
+	switch encoding {
+	case "base64url":
+		return base64.RawURLEncoding.EncodeToString(bytes), nil
+	case "base58":
+		return encodeBase58(bytes), nil
+	default:
+		return hex.EncodeToString(bytes), nil
+	}
 }
 
-func (s *SaleService) ProcessCheckout(ctx context.Context, userID string, itemID int64) (string, error) {
-	activeSale, err := s.dbStore.GetActiveSale()
+// CheckoutMeta carries request metadata recorded alongside a checkout attempt
+// for abuse analysis.
+type CheckoutMeta struct {
+	ClientIP  string
+	UserAgent string
+}
+
+// CheckoutResult is a successful checkout: the code and the user's purchase
+// allowance at the time of the checkout.
+type CheckoutResult struct {
+	Code   string
+	ItemID int64
+	// UserLimit is the per-sale purchase cap and UserRemaining how many more
+	// items the user may buy, also bounded by the global limit if one is set.
+	UserLimit     int
+	UserRemaining int
+}
+
+func (s *SaleService) ProcessCheckout(ctx context.Context, userID string, itemID int64, meta CheckoutMeta) (_ *CheckoutResult, err error) {
+	defer mapUnavailable(&err)
+	ctx, span := startSpan(ctx, "SaleService.ProcessCheckout", attribute.Int64("item_id", itemID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	elig, err := traceStore(ctx, "CheckCheckoutEligibility", func() (*store.CheckoutEligibility, error) {
+		return s.dbStore.CheckCheckoutEligibility(userID, itemID)
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to get active sale: %w", err)
+		return nil, fmt.Errorf("failed to check checkout eligibility: %w", err)
 	}
-	if activeSale == nil {
-		return "", ErrSaleNotActive
+	activeSale := elig.Sale
+	if err := s.checkSaleOpen(ctx, activeSale); err != nil {
+		return nil, err
+	}
+	span.SetAttributes(attribute.Int64("sale_id", activeSale.ID))
+
+	if !elig.ItemAvailable {
+		fromOtherSale := elig.ItemSaleID != 0 && elig.ItemSaleID != activeSale.ID
+		if s.cfg().RejectInactiveSaleItemCheckout && fromOtherSale {
+			return nil, ErrItemFromInactiveSale
+		}
+		if s.cfg().RejectOwnedItemCheckout && elig.ItemSold && !fromOtherSale && s.userOwnsItem(ctx, userID, itemID, activeSale.ID) {
+			return nil, ErrItemAlreadyPurchased
+		}
+		return nil, s.unavailableItemError(ctx, activeSale)
+	}
+
+	if result, err := s.reuseOpenCheckout(ctx, userID, itemID, activeSale, elig.UserPurchases); result != nil || err != nil {
+		return result, err
+	}
+
+	result, err := s.userAllowance(ctx, userID, activeSale, elig.UserPurchases)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.admitCheckout(ctx, activeSale, userID); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			s.releaseCheckoutSlot(ctx, activeSale.ID, userID)
+		}
+	}()
+
+	result.ItemID = itemID
+	if err := s.issueCheckoutCode(ctx, activeSale, userID, result, meta); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// userOwnsItem reports whether the user bought the item in the sale. It is
+// only consulted once the item turned out to be sold, so checkouts of
+// available items pay nothing for it. Lookup failures count as not owned.
+func (s *SaleService) userOwnsItem(ctx context.Context, userID string, itemID, saleID int64) bool {
+	owned, err := traceStore(ctx, "HasUserPurchasedItem", func() (bool, error) {
+		return s.dbStore.HasUserPurchasedItem(userID, itemID, saleID)
+	})
+	if err != nil {
+		s.logger.Printf("Warning: failed to check whether user %s bought item %d: %v\n", userID, itemID, err)
+		return false
+	}
+	return owned
+}
+
+// unavailableItemError explains why an item could not be checked out:
+// ErrSaleLimitReached when nothing in the sale is left, which also sets the
+// sold-out flag for later checkouts, and ErrItemNotFoundOrSold otherwise.
+func (s *SaleService) unavailableItemError(ctx context.Context, sale *models.Sale) error {
+	available, err := traceStore(ctx, "HasAvailableItems", func() (bool, error) {
+		return s.dbStore.HasAvailableItems(sale.ID)
+	})
+	if err != nil {
+		s.logger.Printf("Warning: failed to check remaining inventory for sale %d: %v\n", sale.ID, err)
+		return ErrItemNotFoundOrSold
+	}
+	if !available {
+		s.markSaleSoldOut(ctx, sale)
+		return ErrSaleLimitReached
+	}
+	return ErrItemNotFoundOrSold
+}
+
+// saleForCheckout returns the active sale if it is open for checkouts.
+func (s *SaleService) saleForCheckout(ctx context.Context) (*models.Sale, error) {
+	activeSale, err := traceStore(ctx, "GetActiveSale", s.dbStore.GetActiveSale)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active sale: %w", err)
+	}
+	if err := s.checkSaleOpen(ctx, activeSale); err != nil {
+		return nil, err
+	}
+	return activeSale, nil
+}
+
+// checkSaleOpen returns nil if sale, the active sale or nil when there is
+// none, is open for checkouts.
+func (s *SaleService) checkSaleOpen(ctx context.Context, sale *models.Sale) error {
+	if sale == nil {
+		return ErrSaleNotActive
+	}
+	s.rememberActiveSale(ctx, sale)
+	if IsSaleInPreview(sale, time.Now()) {
+		return &SaleNotStartedError{OpensAt: sale.StartTime}
 	}
 
-	item, err := s.dbStore.GetItemForCheckout(itemID, activeSale.ID)
+	soldOut, err := s.redisStore.IsSaleSoldOut(ctx, sale.ID)
 	if err != nil {
-		return "", fmt.Errorf("failed to get item details: %w", err)
+		s.logger.Printf("Warning: failed to check sold-out flag for sale %d: %v\n", sale.ID, err)
 	}
-	if item == nil || item.IsSold {
-		return "", ErrItemNotFoundOrSold
+	if soldOut {
+		return ErrSaleLimitReached
 	}
+	return nil
+}
+
+// minReusableCodeLifetime is how long an open checkout code must still be
+// valid to be handed out again instead of minting a new one.
+const minReusableCodeLifetime = 30 * time.Second
 
-	userPurchaseCount, err := s.dbStore.GetUserPurchaseCountForSale(userID, activeSale.ID)
+// reuseOpenCheckout returns the user's open code for the item, when they
+// already hold one, so a repeated checkout such as a double-click does not
+// reserve the item twice. It returns nil when a new code is needed. purchased
+// is the user's purchase count in the sale.
+func (s *SaleService) reuseOpenCheckout(ctx context.Context, userID string, itemID int64, sale *models.Sale, purchased int) (*CheckoutResult, error) {
+	attempt, err := traceStore(ctx, "GetOpenCheckoutAttempt", func() (*models.CheckoutAttempt, error) {
+		return s.dbStore.GetOpenCheckoutAttempt(userID, itemID, sale.ID, time.Now().Add(minReusableCodeLifetime))
+	})
 	if err != nil {
-		return "", fmt.Errorf("failed to get user purchase count: %w", err)
+		s.logger.Printf("Warning: failed to look up open checkout of item %d for user %s: %v\n", itemID, userID, err)
+		return nil, nil
 	}
-	if userPurchaseCount >= userMaxItemsPerSale {
-		return "", ErrUserLimitReached
+	if attempt == nil {
+		return nil, nil
 	}
 
-	checkoutCode, err := generateUniqueID(16)
+	// The code already counts toward the reservation cap, so only the
+	// purchase limits apply.
+	result, err := s.purchaseAllowance(ctx, userID, sale, purchased)
 	if err != nil {
-		return "", fmt.Errorf("%w: failed to generate unique code: %v", ErrCheckoutFailed, err)
+		return nil, err
 	}
+	result.Code = attempt.ID
+	result.ItemID = attempt.ItemID
+	return result, nil
+}
 
-	codeExpiryDuration := s.config.CodeTTLExpiry
+// userAllowance checks the user lists admit the user and that they may buy
+// another item in the sale and hold another reservation, and returns a
+// result carrying their limit and remaining purchases. purchased is the
+// user's purchase count in the sale.
+func (s *SaleService) userAllowance(ctx context.Context, userID string, sale *models.Sale, purchased int) (*CheckoutResult, error) {
+	result, err := s.purchaseAllowance(ctx, userID, sale, purchased)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.cfg().MaxActiveReservations > 0 {
+		active, err := traceStore(ctx, "CountActiveCheckoutAttempts", func() (int, error) {
+			return s.dbStore.CountActiveCheckoutAttempts(userID, sale.ID)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to count active reservations: %w", err)
+		}
+		if active >= s.cfg().MaxActiveReservations {
+			return nil, ErrTooManyReservations
+		}
+	}
+	return result, nil
+}
+
+// purchaseAllowance is userAllowance without the reservation cap.
+func (s *SaleService) purchaseAllowance(ctx context.Context, userID string, sale *models.Sale, purchased int) (*CheckoutResult, error) {
+	if err := s.checkUserAccess(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	userLimit := s.userLimitForSale(sale)
+	if purchased >= userLimit {
+		return nil, ErrUserLimitReached
+	}
+	userRemaining := userLimit - purchased
+
+	if s.cfg().GlobalUserLimit > 0 {
+		totalPurchases, err := traceStore(ctx, "GetUserTotalPurchases", func() (int, error) {
+			return s.dbStore.GetUserTotalPurchases(userID)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user total purchases: %w", err)
+		}
+		if totalPurchases >= s.cfg().GlobalUserLimit {
+			return nil, ErrGlobalUserLimitReached
+		}
+		userRemaining = min(userRemaining, s.cfg().GlobalUserLimit-totalPurchases)
+	}
+
+	return &CheckoutResult{UserLimit: userLimit, UserRemaining: userRemaining}, nil
+}
+
+// issueCheckoutCode records a checkout attempt for result.ItemID and fills
+// in result.Code.
+func (s *SaleService) issueCheckoutCode(ctx context.Context, sale *models.Sale, userID string, result *CheckoutResult, meta CheckoutMeta) error {
+	return s.issueCheckoutCodeWith(ctx, sale, userID, result, meta, s.dbStore.CreateCheckoutAttempt)
+}
+
+// issueCheckoutCodeWith is issueCheckoutCode with the database insert
+// supplied by the caller. insert may pick the item itself, setting the
+// attempt's ItemID, which is then copied into result.
+func (s *SaleService) issueCheckoutCodeWith(ctx context.Context, sale *models.Sale, userID string, result *CheckoutResult, meta CheckoutMeta, insert func(*models.CheckoutAttempt) error) error {
+	codeExpiryDuration := s.cfg().CodeTTLExpiry
 
 	checkoutAttempt := &models.CheckoutAttempt{
-		ID:        checkoutCode,
 		UserID:    userID,
-		ItemID:    itemID,
-		SaleID:    activeSale.ID,
+		ItemID:    result.ItemID,
+		SaleID:    sale.ID,
 		ExpiresAt: time.Now().Add(codeExpiryDuration),
 		IsUsed:    false,
+		ClientIP:  meta.ClientIP,
+		UserAgent: meta.UserAgent,
 	}
 
-	if err := s.dbStore.CreateCheckoutAttempt(checkoutAttempt); err != nil {
-		return "", fmt.Errorf("%w: failed to save checkout attempt: %v", ErrCheckoutFailed, err)
+	if err := s.createCheckoutAttempt(ctx, checkoutAttempt, insert); err != nil {
+		return err
 	}
+	result.Code = checkoutAttempt.ID
+	result.ItemID = checkoutAttempt.ItemID
 
 	if err := s.redisStore.StoreCheckoutCode(ctx, checkoutAttempt, codeExpiryDuration); err != nil {
-		s.logger.Printf("Warning: failed to store checkout code %s in Redis: %v\n", checkoutCode, err)
+		s.logger.Printf("Warning: failed to store checkout code %s in Redis: %v\n", result.Code, err)
 	}
+	s.occupyCheckoutSlot(ctx, checkoutAttempt)
+	s.recordFunnel(ctx, sale.ID, funnelCheckoutCreated)
+	return nil
+}
+
+// createCheckoutAttempt assigns a fresh code to the attempt and persists it
+// with insert, regenerating the code if it collides with an existing one.
+func (s *SaleService) createCheckoutAttempt(ctx context.Context, attempt *models.CheckoutAttempt, insert func(*models.CheckoutAttempt) error) error {
+	for i := 0; i < maxCodeGenerationAttempts; i++ {
+		code, err := generateUniqueID(s.cfg().CheckoutCodeBytes, s.cfg().CheckoutCodeEncoding)
+		if err != nil {
+			return fmt.Errorf("%w: failed to generate unique code: %v", ErrCheckoutFailed, err)
+		}
+		attempt.ID = code
+
+		_, err = traceStore(ctx, "CreateCheckoutAttempt", func() (struct{}, error) {
+			return struct{}{}, insert(attempt)
+		})
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, store.ErrDBDuplicateCheckoutCode) {
+			return fmt.Errorf("%w: failed to save checkout attempt: %v", ErrCheckoutFailed, err)
+		}
+		s.logger.Printf("Warning: generated checkout code collided with an existing one, regenerating.\n")
+	}
+	return fmt.Errorf("%w: could not generate a unique checkout code after %d attempts", ErrCheckoutFailed, maxCodeGenerationAttempts)
+}
+
+// GetUserLimit reports how many more items the user may buy in the active
+// sale. Without an active sale nothing can be bought, so Remaining is zero.
+func (s *SaleService) GetUserLimit(ctx context.Context, userID string) (*models.UserLimit, error) {
+	activeSale, err := s.readStore.GetActiveSale()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get active sale: %w", err)
+	}
+	if activeSale == nil {
+		return &models.UserLimit{UserID: userID, Limit: s.cfg().MaxItemsPerUser, Items: []models.Item{}}, nil
+	}
+
+	used, err := s.readStore.GetUserPurchaseCountForSale(userID, activeSale.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user purchase count: %w", err)
+	}
+
+	items, err := s.readStore.GetUserItemsForSale(userID, activeSale.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user items: %w", err)
+	}
+	for i := range items {
+		s.resolveImageURL(&items[i])
+	}
+
+	limit := s.userLimitForSale(activeSale)
+	return &models.UserLimit{
+		UserID:     userID,
+		SaleID:     activeSale.ID,
+		SaleActive: true,
+		Limit:      limit,
+		Used:       used,
+		Remaining:  max(limit-used, 0),
+		Items:      items,
+	}, nil
+}
+
+// GetUserSales lists the sales the user bought items in, newest first.
+func (s *SaleService) GetUserSales(ctx context.Context, userID string) (_ []models.UserSale, err error) {
+	ctx, span := startSpan(ctx, "SaleService.GetUserSales")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	return traceStore(ctx, "GetUserSales", func() ([]models.UserSale, error) {
+		return s.readStore.GetUserSales(userID)
+	})
+}
+
+// ReleaseUserReservations invalidates all outstanding checkout codes the user
+// holds in the active sale and returns the sale and how many were released.
+func (s *SaleService) ReleaseUserReservations(ctx context.Context, userID string) (*models.Sale, int, error) {
+	activeSale, err := s.dbStore.GetActiveSale()
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to get active sale: %w", err)
+	}
+	if activeSale == nil {
+		return nil, 0, ErrSaleNotActive
+	}
+
+	codes, err := s.dbStore.ExpireUserCheckoutAttempts(userID, activeSale.ID)
+	if err != nil {
+		return nil, 0, err
+	}
+	// The database now refuses the codes at purchase, so a failed Redis
+	// delete only leaves a stale copy behind.
+	for _, code := range codes {
+		if err := s.redisStore.DeleteCheckoutCode(ctx, code); err != nil {
+			s.logger.Printf("Warning: failed to delete released checkout code %s from Redis: %v\n", code, err)
+		}
+		s.freeCheckoutSlot(ctx, &models.CheckoutAttempt{ID: code, SaleID: activeSale.ID})
+	}
+
+	s.logger.Printf("Released %d checkout codes for user %s in sale %d.", len(codes), userID, activeSale.ID)
+	return activeSale, len(codes), nil
+}
 
-	return checkoutCode, nil
+func (s *SaleService) CheckoutCountsByIP(ctx context.Context, saleID int64, limit int) ([]models.IPCheckoutCount, error) {
+	return s.readStore.CountCheckoutsByIP(saleID, min(limit, MaxAdminItemsPage))
 }
 
-func (s *SaleService) ProcessPurchase(ctx context.Context, code string) (*models.Item, error) {
-	checkoutAttempt, err := s.getValidCheckoutAttempt(ctx, code)
+func (s *SaleService) GetSaleStats(ctx context.Context, saleID int64) (*models.SaleStats, error) {
+	stats, err := traceStore(ctx, "GetSaleStats", func() (*models.SaleStats, error) {
+		return s.readStore.GetSaleStats(saleID)
+	})
 	if err != nil {
 		return nil, err
 	}
+	if stats == nil {
+		return nil, ErrSaleNotFound
+	}
+	return stats, nil
+}
+
+// MaxReportRange bounds the date range of a sales report.
+const MaxReportRange = 31 * 24 * time.Hour
+
+// GetSaleReports returns the report rows for sales started in [from, to).
+func (s *SaleService) GetSaleReports(ctx context.Context, from, to time.Time) ([]models.SaleReport, error) {
+	if !from.Before(to) || to.Sub(from) > MaxReportRange {
+		return nil, ErrInvalidReportRange
+	}
+	return traceStore(ctx, "ListSaleReports", func() ([]models.SaleReport, error) {
+		return s.readStore.ListSaleReports(from, to)
+	})
+}
+
+// ImageTemplatePlaceholders are the placeholders ReimageSale substitutes.
+var ImageTemplatePlaceholders = []string{"{sale_id}", "{item_id}", "{file}"}
+
+// ReimageSale points every item of the sale at a new image path built from
+// template, e.g. "cdn-v2/{sale_id}/{file}". Relative results are resolved
+// against ImageBaseURL like the original paths. Checkouts and purchases do
+// not read image URLs, so this is safe on an active sale; purchases of the
+// sale's items merely wait for the single UPDATE to commit.
+func (s *SaleService) ReimageSale(ctx context.Context, saleID int64, template string) (_ int64, err error) {
+	ctx, span := startSpan(ctx, "SaleService.ReimageSale", attribute.Int64("sale_id", saleID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	sale, err := traceStore(ctx, "GetSaleByID", func() (*models.Sale, error) {
+		return s.dbStore.GetSaleByID(saleID)
+	})
+	if err != nil {
+		return 0, err
+	}
+	if sale == nil {
+		return 0, ErrSaleNotFound
+	}
+	if err := s.validateImageTemplate(saleID, template); err != nil {
+		return 0, err
+	}
+
+	updated, err := traceStore(ctx, "ReimageSaleItems", func() (int64, error) {
+		return s.dbStore.ReimageSaleItems(saleID, template)
+	})
+	if err != nil {
+		return 0, err
+	}
+	s.logger.Printf("Reimaged %d items of sale ID %d with template %q.", updated, saleID, template)
+	return updated, nil
+}
+
+// DefaultMaintenanceMessage is reported when maintenance mode is enabled
+// without a custom message.
+const DefaultMaintenanceMessage = "checkouts and purchases are temporarily paused for maintenance"
+
+// PurgeSale deletes everything a finished sale left behind except the sale
+// itself, to keep test databases small across many cycles.
+func (s *SaleService) PurgeSale(ctx context.Context, saleID int64) (_ *models.SalePurge, err error) {
+	ctx, span := startSpan(ctx, "SaleService.PurgeSale", attribute.Int64("sale_id", saleID))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	purge, err := traceStore(ctx, "PurgeSale", func() (*models.SalePurge, error) {
+		return s.dbStore.PurgeSale(saleID)
+	})
+	if errors.Is(err, store.ErrDBSaleNotFinished) {
+		return nil, ErrSaleNotFinished
+	}
+	if err != nil {
+		return nil, err
+	}
+	if purge == nil {
+		return nil, ErrSaleNotFound
+	}
+	s.logger.Printf("Purged sale ID %d: %d purchases, %d checkout attempts, %d user limits, %d items.",
+		saleID, purge.Purchases, purge.CheckoutAttempts, purge.UserLimits, purge.Items)
+	return purge, nil
+}
+
+// PoolStats reports the database and Redis connection pools of this
+// instance.
+func (s *SaleService) PoolStats() *models.PoolStats {
+	stats := &models.PoolStats{
+		Primary: s.dbStore.PoolStats(),
+		Redis:   s.redisStore.PoolStats(),
+	}
+	if s.readStore != s.dbStore {
+		replica := s.readStore.PoolStats()
+		stats.Replica = &replica
+	}
+	return stats
+}
 
-	purchasedItem, err := s.dbStore.ExecutePurchaseTransaction(
-		checkoutAttempt.UserID,
-		checkoutAttempt.ItemID,
-		checkoutAttempt.SaleID,
-		checkoutAttempt.ID,
-		userMaxItemsPerSale,
+// MaintenanceStatus reads the maintenance flag shared by all replicas.
+func (s *SaleService) MaintenanceStatus(ctx context.Context) (*models.MaintenanceStatus, error) {
+	enabled, message, err := s.redisStore.GetMaintenance(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if enabled && message == "" {
+		message = DefaultMaintenanceMessage
+	}
+	return &models.MaintenanceStatus{Enabled: enabled, Message: message}, nil
+}
+
+func (s *SaleService) SetMaintenance(ctx context.Context, enabled bool, message string) error {
+	if err := s.redisStore.SetMaintenance(ctx, enabled, message); err != nil {
+		return err
+	}
+	s.logger.Printf("Maintenance mode set: enabled=%t message=%q", enabled, message)
+	return nil
+}
+
+// ProcessPurchase completes the purchase reserved by code once its payment
+// is verified.
+func (s *SaleService) ProcessPurchase(ctx context.Context, code, paymentReference string) (_ *models.Item, err error) {
+	ctx, span := startSpan(ctx, "SaleService.ProcessPurchase")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	code, err = s.resolveCheckoutCode(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	checkoutAttempt, sale, err := s.getValidCheckoutAttempt(ctx, code)
+	if errors.Is(err, ErrCheckoutCodeAlreadyUsed) {
+		if item := s.replayedPurchase(ctx, code); item != nil {
+			return item, nil
+		}
+	}
+	if checkoutAttempt != nil {
+		defer func() { s.recordPurchaseOutcome(ctx, checkoutAttempt.SaleID, err) }()
+	}
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(
+		attribute.Int64("sale_id", checkoutAttempt.SaleID),
+		attribute.Int64("item_id", checkoutAttempt.ItemID),
 	)
+
+	params, err := s.purchaseParams(ctx, checkoutAttempt, sale, paymentReference)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.purchases.acquire(ctx); err != nil {
+		return nil, err
+	}
+	purchasedItem, remaining, err := s.executePurchaseWithRetry(ctx, params)
+	s.purchases.release()
+	if err != nil {
+		return nil, s.purchaseFailure(ctx, code, sale, err)
+	}
+
+	s.completePurchase(ctx, checkoutAttempt, sale, purchasedItem, remaining)
+	return purchasedItem, nil
+}
+
+// purchaseParams runs the checks a purchase must pass before its
+// transaction, verifying its payment, and returns the transaction's
+// parameters.
+func (s *SaleService) purchaseParams(ctx context.Context, attempt *models.CheckoutAttempt, sale *models.Sale, paymentReference string) (store.PurchaseParams, error) {
+	if s.cfg().MinPurchaseDwell > 0 && time.Since(attempt.CreatedAt) < s.cfg().MinPurchaseDwell {
+		return store.PurchaseParams{}, ErrPurchaseTooSoon
+	}
+	if err := s.checkPurchaseVelocity(ctx, attempt.UserID); err != nil {
+		return store.PurchaseParams{}, err
+	}
+
+	if err := s.verifyPayment(ctx, attempt, paymentReference); err != nil {
+		if !errors.Is(err, ErrPaymentReferenceRequired) && !errors.Is(err, ErrPaymentNotVerified) && !errors.Is(err, ErrItemNotFoundOrSold) {
+			s.logger.Printf("Error verifying payment for code %s: %v\n", attempt.ID, err)
+			return store.PurchaseParams{}, ErrPurchaseFailed
+		}
+		return store.PurchaseParams{}, err
+	}
+
+	return store.PurchaseParams{
+		UserID:           attempt.UserID,
+		ItemID:           attempt.ItemID,
+		SaleID:           attempt.SaleID,
+		CheckoutCode:     attempt.ID,
+		UserLimitPerSale: s.userLimitForSale(sale),
+		GlobalUserLimit:  s.cfg().GlobalUserLimit,
+		SKULimit:         s.cfg().MaxItemsPerSKU,
+		Isolation:        purchaseIsolationLevels[s.cfg().PurchaseIsolation],
+		PaymentReference: paymentReference,
+	}, nil
+}
+
+// purchaseFailure translates the error of a purchase transaction into the
+// service error reported for code.
+func (s *SaleService) purchaseFailure(ctx context.Context, code string, sale *models.Sale, err error) error {
+	if errors.Is(err, store.ErrDBItemAlreadySold) || errors.Is(err, store.ErrDBItemDisabled) {
+		return ErrItemNotFoundOrSold
+	}
+	if errors.Is(err, store.ErrDBSaleLimitReached) {
+		s.markSaleSoldOut(ctx, sale)
+		return ErrSaleLimitReached
+	}
+	if errors.Is(err, store.ErrDBUserPurchaseLimitReached) {
+		return ErrUserLimitReached
+	}
+	if errors.Is(err, store.ErrDBCheckoutCodeAlreadyUsed) {
+		return ErrCheckoutCodeAlreadyUsed
+	}
+	if errors.Is(err, store.ErrDBCheckoutCodeExpired) {
+		return ErrCheckoutCodeExpired
+	}
+	if errors.Is(err, store.ErrDBGlobalUserLimitReached) {
+		return ErrGlobalUserLimitReached
+	}
+	if errors.Is(err, store.ErrDBSKULimitReached) {
+		return ErrSKULimitReached
+	}
+	if errors.Is(err, store.ErrDBSaleNotActive) {
+		return ErrSaleNotActive
+	}
+	if errors.Is(err, store.ErrDBSaleNotStarted) {
+		return &SaleNotStartedError{OpensAt: sale.StartTime}
+	}
+	if errors.Is(err, store.ErrDBPaymentReferenceUsed) {
+		return ErrPaymentReferenceUsed
+	}
+	if errors.Is(err, store.ErrDBCheckoutAttemptNotFound) {
+		s.logger.Printf("Warning: checkout code %s found in Redis but not in the database; rejecting it.\n", code)
+		if err := s.redisStore.DeleteCheckoutCode(ctx, code); err != nil {
+			s.logger.Printf("Warning: failed to delete orphaned checkout code %s from Redis: %v\n", code, err)
+		}
+		return ErrCheckoutCodeInvalid
+	}
+	s.logger.Printf("Error during ExecutePurchaseTransaction for code %s: %v\n", code, err)
+	return ErrPurchaseFailed
+}
+
+// completePurchase updates Redis after the purchase with attempt committed.
+func (s *SaleService) completePurchase(ctx context.Context, attempt *models.CheckoutAttempt, sale *models.Sale, item *models.Item, remaining int) {
+	if err := s.redisStore.MarkCheckoutCodeUsed(ctx, attempt); err != nil {
+		s.logger.Printf("Warning: failed to mark checkout code %s used in Redis after successful purchase: %v\n", attempt.ID, err)
+	}
+	s.freeCheckoutSlot(ctx, attempt)
+	if s.cfg().PurchaseReplayTTL > 0 {
+		s.setCached(ctx, purchaseReplayCacheName(attempt.ID), item, s.cfg().PurchaseReplayTTL)
+	}
+	if remaining <= 0 {
+		s.markSaleSoldOut(ctx, sale)
+	}
+	s.checkSellThrough(ctx, sale, remaining)
+}
+
+func purchaseReplayCacheName(code string) string {
+	return "purchase:" + code
+}
+
+// replayedPurchase returns the item bought with code when that purchase
+// happened within PurchaseReplayTTL, so a client retrying after losing the
+// response gets its success again. It returns nil otherwise.
+func (s *SaleService) replayedPurchase(ctx context.Context, code string) *models.Item {
+	if s.cfg().PurchaseReplayTTL <= 0 {
+		return nil
+	}
+	var item models.Item
+	if !s.getCached(ctx, purchaseReplayCacheName(code), &item) {
+		return nil
+	}
+	return &item
+}
+
+// purchaseIsolationLevels maps the PURCHASE_ISOLATION settings to their
+// transaction isolation levels.
+var purchaseIsolationLevels = map[string]sql.IsolationLevel{
+	"read_committed":  sql.LevelReadCommitted,
+	"repeatable_read": sql.LevelRepeatableRead,
+	"serializable":    sql.LevelSerializable,
+}
+
+const (
+	maxPurchaseAttempts    = 3
+	purchaseRetryBaseDelay = 10 * time.Millisecond
+)
+
+// executePurchaseWithRetry re-runs the purchase transaction when Postgres
+// aborts it for a serialization failure or deadlock.
+func (s *SaleService) executePurchaseWithRetry(ctx context.Context, params store.PurchaseParams) (*models.Item, int, error) {
+	var item *models.Item
+	var remaining int
+	err := s.retryPurchase(ctx, "purchase for code "+params.CheckoutCode, func() error {
+		var err error
+		item, err = traceStore(ctx, "ExecutePurchaseTransaction", func() (*models.Item, error) {
+			item, left, err := s.dbStore.ExecutePurchaseTransaction(params)
+			remaining = left
+			return item, err
+		})
+		return err
+	})
 	if err != nil {
-		if errors.Is(err, store.ErrDBItemAlreadySold) {
-			return nil, ErrItemNotFoundOrSold
+		return nil, 0, err
+	}
+	return item, remaining, nil
+}
+
+// retryPurchase runs fn, running it again while it fails with a transaction
+// conflict, backing off exponentially with jitter, up to
+// maxPurchaseAttempts times. Business errors are returned immediately.
+func (s *SaleService) retryPurchase(ctx context.Context, what string, fn func() error) error {
+	delay := purchaseRetryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !store.IsRetryableTxError(err) || attempt == maxPurchaseAttempts {
+			return err
+		}
+
+		s.logger.Printf("Retrying %s after transaction conflict (attempt %d): %v\n", what, attempt, err)
+		select {
+		case <-time.After(delay + time.Duration(rand.Int63n(int64(delay)))):
+		case <-ctx.Done():
+			return ctx.Err()
 		}
-		if errors.Is(err, store.ErrDBSaleLimitReached) {
-			return nil, ErrSaleLimitReached
+		delay *= 2
+	}
+}
+
+// PurchaseResult is the outcome of one code in a batch purchase.
+type PurchaseResult struct {
+	Code string
+	Item *models.Item
+	Err  error
+}
+
+// ProcessPurchaseBatch purchases the codes, which must all belong to one
+// user, in a single transaction, and reports the outcome of each code.
+// The user's limit row is locked once for the whole batch, so once their
+// limit is used up the remaining codes fail with ErrUserLimitReached while
+// earlier purchases stand. A batch mixing users is rejected as a whole
+// with ErrPurchaseBatchMixedUsers. Batch purchases carry no payment
+// reference, so they fail with ErrPaymentReferenceRequired when references
+// are required.
+func (s *SaleService) ProcessPurchaseBatch(ctx context.Context, codes []string) (_ []PurchaseResult, err error) {
+	ctx, span := startSpan(ctx, "SaleService.ProcessPurchaseBatch", attribute.Int("batch_size", len(codes)))
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	results := make([]PurchaseResult, len(codes))
+	attempts := make([]*models.CheckoutAttempt, len(codes))
+	sales := make([]*models.Sale, len(codes))
+	var pending []int
+	var params []store.PurchaseParams
+	seen := make(map[string]bool, len(codes))
+	userID := ""
+	for i, code := range codes {
+		results[i].Code = code
+		resolved, err := s.resolveCheckoutCode(ctx, code)
+		if err != nil {
+			results[i].Err = err
+			continue
 		}
-		if errors.Is(err, store.ErrDBUserPurchaseLimitReached) {
-			return nil, ErrUserLimitReached
+		if seen[resolved] {
+			results[i].Err = ErrCheckoutCodeAlreadyUsed
+			continue
+		}
+		seen[resolved] = true
+
+		attempt, sale, err := s.getValidCheckoutAttempt(ctx, resolved)
+		if attempt != nil {
+			if userID != "" && attempt.UserID != userID {
+				return nil, ErrPurchaseBatchMixedUsers
+			}
+			userID = attempt.UserID
+			attempts[i] = attempt
+		}
+		if errors.Is(err, ErrCheckoutCodeAlreadyUsed) {
+			if item := s.replayedPurchase(ctx, resolved); item != nil {
+				results[i].Item = item
+				attempts[i] = nil
+				continue
+			}
+		}
+		if err != nil {
+			results[i].Err = err
+			continue
 		}
-		s.logger.Printf("Error during ExecutePurchaseTransaction for code %s: %v\n", code, err)
-		return nil, ErrPurchaseFailed
+
+		p, err := s.purchaseParams(ctx, attempt, sale, "")
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		sales[i] = sale
+		pending = append(pending, i)
+		params = append(params, p)
+	}
+	defer func() {
+		for i, attempt := range attempts {
+			if attempt != nil {
+				s.recordPurchaseOutcome(ctx, attempt.SaleID, results[i].Err)
+			}
+		}
+	}()
+	if len(params) == 0 {
+		return results, nil
 	}
 
-	if err := s.redisStore.DeleteCheckoutCode(ctx, code); err != nil {
-		s.logger.Printf("Warning: failed to delete checkout code %s from Redis after successful purchase: %v\n", code, err)
+	if err := s.purchases.acquire(ctx); err != nil {
+		for _, i := range pending {
+			results[i].Err = err
+		}
+		return results, nil
+	}
+	var outcomes []store.PurchaseOutcome
+	err = s.retryPurchase(ctx, fmt.Sprintf("batch purchase of %d codes", len(params)), func() error {
+		var err error
+		outcomes, err = traceStore(ctx, "ExecutePurchaseBatch", func() ([]store.PurchaseOutcome, error) {
+			return s.dbStore.ExecutePurchaseBatch(params, purchaseIsolationLevels[s.cfg().PurchaseIsolation])
+		})
+		return err
+	})
+	s.purchases.release()
+	if err != nil {
+		s.logger.Printf("Error during ExecutePurchaseBatch of %d codes: %v\n", len(params), err)
+		for _, i := range pending {
+			results[i].Err = ErrPurchaseFailed
+		}
+		return results, nil
 	}
 
-	return purchasedItem, nil
+	for n, i := range pending {
+		outcome := outcomes[n]
+		if outcome.Err != nil {
+			results[i].Err = s.purchaseFailure(ctx, attempts[i].ID, sales[i], outcome.Err)
+			continue
+		}
+		s.completePurchase(ctx, attempts[i], sales[i], outcome.Item, outcome.Remaining)
+		results[i].Item = outcome.Item
+	}
+	return results, nil
+}
+
+// CheckoutStatus reports whether a code could still be used to purchase,
+// without consuming it. Codes that do not exist or belong to another user
+// are both reported as ErrCheckoutCodeInvalid, so the endpoint cannot be
+// used to probe for other users' codes.
+func (s *SaleService) CheckoutStatus(ctx context.Context, code, userID string) (_ *models.CheckoutStatus, err error) {
+	ctx, span := startSpan(ctx, "SaleService.CheckoutStatus")
+	defer func() { telemetry.EndSpan(span, err) }()
+
+	attempt, _, err := s.getValidCheckoutAttempt(ctx, code)
+	if attempt == nil || attempt.UserID != userID {
+		if err != nil && !isCheckoutRejection(err) {
+			return nil, err
+		}
+		return nil, ErrCheckoutCodeInvalid
+	}
+
+	status := &models.CheckoutStatus{
+		Code:      attempt.ID,
+		Valid:     err == nil,
+		Used:      attempt.IsUsed,
+		ExpiresAt: attempt.ExpiresAt,
+		SaleID:    attempt.SaleID,
+		ItemID:    attempt.ItemID,
+	}
+	if err != nil {
+		status.Reason = err.Error()
+	}
+	return status, nil
+}
+
+// isCheckoutRejection reports whether getValidCheckoutAttempt refused the
+// code for a reason of the code or its sale, rather than failing to look it
+// up.
+func isCheckoutRejection(err error) bool {
+	return errors.Is(err, ErrCheckoutCodeInvalid) ||
+		errors.Is(err, ErrCheckoutCodeAlreadyUsed) ||
+		errors.Is(err, ErrCheckoutCodeExpired) ||
+		errors.Is(err, ErrSaleNotActive) ||
+		errors.Is(err, ErrSaleNotStarted)
+}
+
+// checkPurchaseVelocity refuses a purchase when the user has already bought
+// PurchaseVelocityLimit items, across all sales, within
+// PurchaseVelocityWindow. Per-sale limits reset with each sale; this catches
+// bots that cycle through them. A failed count lets the purchase through.
+func (s *SaleService) checkPurchaseVelocity(ctx context.Context, userID string) error {
+	if s.cfg().PurchaseVelocityLimit <= 0 {
+		return nil
+	}
+	count, err := traceStore(ctx, "CountPurchasesSince", func() (int, error) {
+		return s.dbStore.CountPurchasesSince(userID, time.Now().Add(-s.cfg().PurchaseVelocityWindow))
+	})
+	if err != nil {
+		s.logger.Printf("Warning: failed to check purchase velocity for user %s: %v\n", userID, err)
+		return nil
+	}
+	if count >= s.cfg().PurchaseVelocityLimit {
+		s.logger.Printf("ALERT: user %s blocked for purchase velocity: %d purchases in the last %s",
+			userID, count, s.cfg().PurchaseVelocityWindow)
+		return ErrPurchaseVelocityExceeded
+	}
+	return nil
+}
+
+// userPurchaseCount returns how many items the user has bought in the sale.
+func (s *SaleService) userPurchaseCount(ctx context.Context, userID string, saleID int64) (int, error) {
+	count, err := traceStore(ctx, "GetUserPurchaseCountForSale", func() (int, error) {
+		return s.dbStore.GetUserPurchaseCountForSale(userID, saleID)
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user purchase count: %w", err)
+	}
+	return count, nil
+}
+
+// userLimitForSale returns the per-user purchase cap for the sale, falling
+// back to the configured default when the sale has no override.
+func (s *SaleService) userLimitForSale(sale *models.Sale) int {
+	if sale.MaxItemsPerUser != nil {
+		return *sale.MaxItemsPerUser
+	}
+	return s.cfg().MaxItemsPerUser
+}
+
+// checkSellThrough logs a warning, once per sale across all replicas, when
+// the sold fraction of the sale reaches SellThroughWarning.
+func (s *SaleService) checkSellThrough(ctx context.Context, sale *models.Sale, remaining int) {
+	threshold := s.cfg().SellThroughWarning
+	if threshold <= 0 || sale.TotalItems <= 0 {
+		return
+	}
+	sold := sale.TotalItems - remaining
+	if float64(sold) < threshold*float64(sale.TotalItems) {
+		return
+	}
+	ttl := time.Until(sale.EndTime)
+	if ttl <= 0 {
+		return
+	}
+
+	first, err := s.redisStore.MarkSellThroughWarned(ctx, sale.ID, ttl)
+	if err != nil {
+		s.logger.Printf("Warning: failed to record sell-through warning for sale %d: %v\n", sale.ID, err)
+		return
+	}
+	if first {
+		s.logger.Printf("ALERT: sale ID %d is %.1f%% sold (%d of %d items), crossing the %.0f%% warning threshold.",
+			sale.ID, float64(sold)*100/float64(sale.TotalItems), sold, sale.TotalItems, threshold*100)
+	}
+}
+
+func (s *SaleService) markSaleSoldOut(ctx context.Context, sale *models.Sale) {
+	ttl := time.Until(sale.EndTime)
+	if ttl <= 0 {
+		return
+	}
+	if err := s.redisStore.MarkSaleSoldOut(ctx, sale.ID, ttl); err != nil {
+		s.logger.Printf("Warning: failed to mark sale %d as sold out in Redis: %v\n", sale.ID, err)
+	}
 }
 
-func (s *SaleService) getValidCheckoutAttempt(ctx context.Context, code string) (*models.CheckoutAttempt, error) {
+// getValidCheckoutAttempt loads the attempt behind a code and checks it can
+// be purchased. Once the attempt is found it is returned even alongside an
+// error, so callers can attribute the failure to its sale.
+func (s *SaleService) getValidCheckoutAttempt(ctx context.Context, code string) (*models.CheckoutAttempt, *models.Sale, error) {
 	attempt, err := s.redisStore.GetCheckoutAttempt(ctx, code)
 	if err != nil {
 		s.logger.Printf("Redis GetCheckoutAttempt error for code %s: %v. Falling back to DB.\n", code, err)
 	}
 
+	cached := attempt != nil
 	if attempt == nil {
 		s.logger.Printf("Code %s not found in Redis, checking DB.\n", code)
-		attempt, err = s.dbStore.GetCheckoutAttemptByID(code)
+		attempt, err = traceStore(ctx, "GetCheckoutAttemptByID", func() (*models.CheckoutAttempt, error) {
+			return s.dbStore.GetCheckoutAttemptByID(code)
+		})
 		if err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				return nil, ErrCheckoutCodeInvalid
+				return nil, nil, ErrCheckoutCodeInvalid
 			}
-			return nil, fmt.Errorf("failed to query checkout attempt from DB: %w", err)
+			return nil, nil, fmt.Errorf("failed to query checkout attempt from DB: %w", err)
 		}
 		if attempt == nil {
-			return nil, ErrCheckoutCodeInvalid
+			return nil, nil, ErrCheckoutCodeInvalid
 		}
 	}
 
 	if attempt.IsUsed {
-		return nil, ErrCheckoutCodeAlreadyUsed
+		return attempt, nil, ErrCheckoutCodeAlreadyUsed
 	}
 	if time.Now().After(attempt.ExpiresAt) {
-		return nil, ErrCheckoutCodeExpired
+		return attempt, nil, ErrCheckoutCodeExpired
 	}
 
-	sale, err := s.dbStore.GetSaleByID(attempt.SaleID)
+	sale, err := traceStore(ctx, "GetSaleByID", func() (*models.Sale, error) {
+		return s.dbStore.GetSaleByID(attempt.SaleID)
+	})
 	if err != nil || sale == nil {
-		return nil, ErrSaleNotActive
+		return attempt, nil, ErrSaleNotActive
 	}
-	if !sale.IsActive || time.Now().After(sale.EndTime) || time.Now().Before(sale.StartTime) {
-		return nil, ErrSaleNotActive
+	// Whether the sale has started or ended is left to the purchase
+	// transaction, which reads the database clock.
+	if !sale.IsActive {
+		return attempt, nil, ErrSaleNotActive
+	}
+
+	// A cached copy may predate the purchase that used the code. When
+	// few items are left, confirm against the primary before queueing.
+	if cached && sale.TotalItems-sale.SoldItems <= s.cfg().ScarceSaleRemaining {
+		fresh, err := traceStore(ctx, "GetCheckoutAttemptByID", func() (*models.CheckoutAttempt, error) {
+			return s.dbStore.GetCheckoutAttemptByID(code)
+		})
+		if err != nil {
+			return attempt, nil, fmt.Errorf("failed to query checkout attempt from DB: %w", err)
+		}
+		if fresh == nil {
+			return attempt, nil, ErrCheckoutCodeInvalid
+		}
+		if fresh.IsUsed {
+			return attempt, nil, ErrCheckoutCodeAlreadyUsed
+		}
 	}
 
-	return attempt, nil
+	return attempt, sale, nil
 }
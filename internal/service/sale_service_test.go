@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"notcoin_contest/internal/store"
+)
+
+func newTestService() *SaleService {
+	return &SaleService{logger: log.New(io.Discard, "", 0)}
+}
+
+func TestRetryPurchase(t *testing.T) {
+	conflict := &pq.Error{Code: "40001"}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{"succeeds after conflicts", []error{conflict, conflict, nil}, 3, nil},
+		{"gives up after max attempts", []error{conflict, conflict, conflict, nil}, maxPurchaseAttempts, conflict},
+		{"business error is not retried", []error{store.ErrDBItemAlreadySold, nil}, 1, store.ErrDBItemAlreadySold},
+		{"user limit is not retried", []error{store.ErrDBUserPurchaseLimitReached, nil}, 1, store.ErrDBUserPurchaseLimitReached},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			err := newTestService().retryPurchase(context.Background(), "test purchase", func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if calls != tt.wantCalls {
+				t.Fatalf("fn ran %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestRetryPurchaseStopsWhenContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	calls := 0
+	err := newTestService().retryPurchase(ctx, "test purchase", func() error {
+		calls++
+		return &pq.Error{Code: "40001"}
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v, want %v", err, context.Canceled)
+	}
+	if calls != 1 {
+		t.Fatalf("fn ran %d times, want 1", calls)
+	}
+}
@@ -0,0 +1,23 @@
+package service
+
+import (
+	"context"
+
+	"notcoin_contest/internal/telemetry"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return telemetry.Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// traceStore runs a store call inside a child span named after it. Store
+// methods do not take a context, so the span is parented from the caller.
+func traceStore[T any](ctx context.Context, name string, call func() (T, error)) (T, error) {
+	_, span := startSpan(ctx, "store."+name)
+	v, err := call()
+	telemetry.EndSpan(span, err)
+	return v, err
+}
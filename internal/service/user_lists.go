@@ -0,0 +1,67 @@
+package service
+
+import (
+	"context"
+)
+
+// User lists moderate who may check out. A denylisted user is always
+// refused; while the allowlist has members, only they may check out.
+const (
+	UserListAllow = "allowlist"
+	UserListDeny  = "denylist"
+)
+
+func validUserList(list string) bool {
+	return list == UserListAllow || list == UserListDeny
+}
+
+// checkUserAccess returns ErrUserNotAllowed if the user lists refuse userID.
+// When Redis cannot be reached it fails closed with ErrUserListsUnavailable
+// if an allowlist was in force at the last successful check, so an outage
+// does not open a restricted sale to everyone. Otherwise it fails open.
+func (s *SaleService) checkUserAccess(ctx context.Context, userID string) error {
+	denied, allowlistActive, allowed, err := s.redisStore.UserListAccess(ctx, userID)
+	if err != nil {
+		if s.allowlistActive.Load() {
+			s.logger.Printf("Warning: failed to check user lists for user %s while an allowlist is in force, refusing: %v\n", userID, err)
+			return ErrUserListsUnavailable
+		}
+		s.logger.Printf("Warning: failed to check user lists for user %s, allowing: %v\n", userID, err)
+		return nil
+	}
+	s.allowlistActive.Store(allowlistActive)
+	if denied || (allowlistActive && !allowed) {
+		return ErrUserNotAllowed
+	}
+	return nil
+}
+
+// AddToUserList puts userID on the allowlist or denylist.
+func (s *SaleService) AddToUserList(ctx context.Context, list, userID string) error {
+	if !validUserList(list) {
+		return ErrUnknownUserList
+	}
+	if err := s.redisStore.AddToUserList(ctx, list, userID); err != nil {
+		return err
+	}
+	if list == UserListAllow {
+		s.allowlistActive.Store(true)
+	}
+	return nil
+}
+
+// RemoveFromUserList takes userID off the allowlist or denylist.
+func (s *SaleService) RemoveFromUserList(ctx context.Context, list, userID string) error {
+	if !validUserList(list) {
+		return ErrUnknownUserList
+	}
+	return s.redisStore.RemoveFromUserList(ctx, list, userID)
+}
+
+// GetUserList returns the members of the allowlist or denylist.
+func (s *SaleService) GetUserList(ctx context.Context, list string) ([]string, error) {
+	if !validUserList(list) {
+		return nil, ErrUnknownUserList
+	}
+	return s.redisStore.GetUserList(ctx, list)
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Warmup opens up to connections pooled database and Redis connections by
+// running the checkout path's queries on each of them at once, so the first
+// requests after startup do not pay for connecting. The eligibility check
+// uses an item ID that matches nothing.
+func (s *SaleService) Warmup(ctx context.Context, connections int) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	record := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+
+	for range connections {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.dbStore.CheckCheckoutEligibility("", 0); err != nil {
+				record(fmt.Errorf("failed to check checkout eligibility: %w", err))
+			}
+			if s.readStore != s.dbStore {
+				if _, err := s.readStore.GetActiveSale(); err != nil {
+					record(fmt.Errorf("failed to get active sale from the replica: %w", err))
+				}
+			}
+			if err := s.redisStore.Ping(ctx); err != nil {
+				record(fmt.Errorf("failed to ping redis: %w", err))
+			}
+		}()
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
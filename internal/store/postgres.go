@@ -3,25 +3,104 @@ package store
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
 	"errors"
 	"fmt"
-	"os"
-	"path/filepath"
+	"io/fs"
+	"net"
 	"sort"
 	"strings"
 	"time"
 
 	"notcoin_contest/internal/models"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 var (
 	ErrDBItemAlreadySold          = errors.New("database: item already sold")
 	ErrDBSaleLimitReached         = errors.New("database: sale item limit reached")
 	ErrDBUserPurchaseLimitReached = errors.New("database: user purchase limit for this sale reached")
+	ErrDBDuplicateCheckoutCode    = errors.New("database: checkout code already exists")
+	ErrDBCheckoutCodeAlreadyUsed  = errors.New("database: checkout code already used for a purchase")
+	ErrDBCheckoutCodeExpired      = errors.New("database: checkout code has expired")
+	ErrDBItemDisabled             = errors.New("database: item is disabled")
+	ErrDBGlobalUserLimitReached   = errors.New("database: user purchase limit across sales reached")
+	ErrDBSKULimitReached          = errors.New("database: user purchase limit for this SKU reached")
+	ErrDBActiveSaleExists         = errors.New("database: another sale is already active")
+	ErrDBSaleNotActive            = errors.New("database: sale is not active or has ended")
+	ErrDBSaleNotStarted           = errors.New("database: sale is still in preview")
+	ErrDBCheckoutAttemptNotFound  = errors.New("database: checkout attempt not found")
+	ErrDBPreparedSaleExists       = errors.New("database: another sale is already prepared")
+	ErrDBNoItemAvailable          = errors.New("database: no unclaimed item available")
+	ErrDBSaleNotFinished          = errors.New("database: sale is active, prepared or not yet ended")
+	ErrDBPaymentReferenceUsed     = errors.New("database: payment reference already used for a purchase")
 )
 
+const (
+	pqUniqueViolation      = "23505"
+	pqSerializationFailure = "40001"
+	pqDeadlockDetected     = "40P01"
+	pqAdminShutdown        = "57P01"
+	pqTooManyConnections   = "53300"
+
+	pqClassConnectionException = "08"
+)
+
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation
+}
+
+const (
+	// purchasesItemUniqueIndex guarantees that an item is purchased at most
+	// once.
+	purchasesItemUniqueIndex = "idx_purchases_item_id_unique"
+	// purchasesPaymentReferenceUniqueIndex guarantees that a payment
+	// reference pays for at most one purchase.
+	purchasesPaymentReferenceUniqueIndex = "idx_purchases_payment_reference_unique"
+)
+
+// violatesConstraint reports whether err is a unique violation of the named
+// constraint or index.
+func violatesConstraint(err error, name string) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == pqUniqueViolation && pqErr.Constraint == name
+}
+
+// IsTransientError reports whether an operation failed for a reason that
+// may clear up on its own: a transaction conflict, or a connection that was
+// lost, refused or shut down by the server.
+func IsTransientError(err error) bool {
+	return IsRetryableTxError(err) || IsUnavailableError(err)
+}
+
+// IsUnavailableError reports whether an operation failed because the
+// database could not be reached or refused the connection, as opposed to
+// failing a query.
+func IsUnavailableError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code.Class() == pqClassConnectionException ||
+			pqErr.Code == pqAdminShutdown || pqErr.Code == pqTooManyConnections
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// IsRetryableTxError reports whether a transaction failed only because it
+// conflicted with a concurrent one and may succeed if run again.
+func IsRetryableTxError(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code == pqSerializationFailure || pqErr.Code == pqDeadlockDetected
+}
+
 type DBStore struct {
 	DB *sql.DB
 }
@@ -30,6 +109,25 @@ func NewDBStore(db *sql.DB) *DBStore {
 	return &DBStore{DB: db}
 }
 
+// withTx runs fn in a transaction at the given isolation level, committing
+// when fn returns nil and rolling back otherwise. Errors from fn are returned
+// as they are, so callers can still match sentinel errors.
+func (s *DBStore) withTx(ctx context.Context, isolation sql.IsolationLevel, fn func(*sql.Tx) error) error {
+	tx, err := s.DB.BeginTx(ctx, &sql.TxOptions{Isolation: isolation})
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
 func ConnectDB(driver, dataSourceName string) (*sql.DB, error) {
 	db, err := sql.Open(driver, dataSourceName)
 	if err != nil {
@@ -47,12 +145,18 @@ func ConnectDB(driver, dataSourceName string) (*sql.DB, error) {
 	return db, nil
 }
 
-func RunMigrations(db *sql.DB, migrationsDir string) error {
-	if migrationsDir == "" {
-		return fmt.Errorf("migrations directory not specified")
+// RunMigrations applies the .sql files at the root of fsys in name order,
+// skipping those already recorded in schema_migrations. Each file runs in its
+// own transaction together with its bookkeeping row, so a failing file leaves
+// no partial changes behind. Migrations are exempt from the connection's
+// statement_timeout, since index builds and backfills on a large database
+// may run far longer than any request query.
+func RunMigrations(db *sql.DB, fsys fs.FS) error {
+	if fsys == nil {
+		return fmt.Errorf("migrations filesystem not specified")
 	}
 
-	entries, err := os.ReadDir(migrationsDir)
+	entries, err := fs.ReadDir(fsys, ".")
 	if err != nil {
 		return fmt.Errorf("failed to read migrations directory: %w", err)
 	}
@@ -72,15 +176,32 @@ func RunMigrations(db *sql.DB, migrationsDir string) error {
 
 	fmt.Printf("Found migration files: %v\n", migrationFiles)
 
+	_, err = db.Exec(`
+        CREATE TABLE IF NOT EXISTS schema_migrations (
+            version VARCHAR(255) PRIMARY KEY,
+            applied_at TIMESTAMP NOT NULL DEFAULT NOW()
+        )`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
 	for _, fileName := range migrationFiles {
-		filePath := filepath.Join(migrationsDir, fileName)
-		content, err := os.ReadFile(filePath)
+		if applied[fileName] {
+			continue
+		}
+
+		content, err := fs.ReadFile(fsys, fileName)
 		if err != nil {
 			return fmt.Errorf("failed to read migration file %s: %w", fileName, err)
 		}
 
-		if _, err := db.Exec(string(content)); err != nil {
-			return fmt.Errorf("failed to execute migration %s: %w", fileName, err)
+		if err := applyMigration(db, fileName, string(content)); err != nil {
+			return fmt.Errorf("migration %s failed and was rolled back, leaving the schema as it was before it: %w", fileName, err)
 		}
 		fmt.Printf("Applied migration: %s\n", fileName)
 	}
@@ -88,6 +209,62 @@ func RunMigrations(db *sql.DB, migrationsDir string) error {
 	return nil
 }
 
+func appliedMigrations(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(`SELECT version FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, fmt.Errorf("failed to scan applied migration: %w", err)
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+func applyMigration(db *sql.DB, version, content string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SET LOCAL statement_timeout = 0`); err != nil {
+		return fmt.Errorf("failed to lift statement timeout: %w", err)
+	}
+	if _, err := tx.Exec(content); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return fmt.Errorf("failed to record migration: %w", err)
+	}
+	return tx.Commit()
+}
+
+// PoolStats reports the state of the connection pool.
+func (s *DBStore) PoolStats() models.DBPoolStats {
+	st := s.DB.Stats()
+	return models.DBPoolStats{
+		MaxOpenConnections: st.MaxOpenConnections,
+		OpenConnections:    st.OpenConnections,
+		InUse:              st.InUse,
+		Idle:               st.Idle,
+		WaitCount:          st.WaitCount,
+		WaitDurationMs:     st.WaitDuration.Milliseconds(),
+		MaxIdleClosed:      st.MaxIdleClosed,
+		MaxIdleTimeClosed:  st.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  st.MaxLifetimeClosed,
+	}
+}
+
 func (s *DBStore) Close() error {
 	if s.DB != nil {
 		return s.DB.Close()
@@ -95,87 +272,318 @@ func (s *DBStore) Close() error {
 	return nil
 }
 
+const saleColumns = `id, title, category, preview_start, start_time, end_time, total_items, sold_items, is_active, max_items_per_user, duration_seconds, created_at, updated_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanSale(row rowScanner) (*models.Sale, error) {
+	sale := &models.Sale{}
+	var title, category sql.NullString
+	var previewStart sql.NullTime
+	var maxItemsPerUser, durationSeconds sql.NullInt32
+	err := row.Scan(
+		&sale.ID,
+		&title,
+		&category,
+		&previewStart,
+		&sale.StartTime,
+		&sale.EndTime,
+		&sale.TotalItems,
+		&sale.SoldItems,
+		&sale.IsActive,
+		&maxItemsPerUser,
+		&durationSeconds,
+		&sale.CreatedAt,
+		&sale.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if maxItemsPerUser.Valid {
+		limit := int(maxItemsPerUser.Int32)
+		sale.MaxItemsPerUser = &limit
+	}
+	if durationSeconds.Valid {
+		seconds := int(durationSeconds.Int32)
+		sale.DurationSeconds = &seconds
+	}
+	sale.Title = title.String
+	sale.Category = category.String
+	if previewStart.Valid {
+		sale.PreviewStart = &previewStart.Time
+	}
+	return sale, nil
+}
+
 func (s *DBStore) CreateSale(sale *models.Sale) (*models.Sale, error) {
+	if err := insertSale(s.DB, sale); err != nil {
+		return nil, err
+	}
+	return sale, nil
+}
+
+const insertSaleQuery = `
+        INSERT INTO sales (title, category, preview_start, start_time, end_time, total_items, sold_items, is_active, max_items_per_user, duration_seconds)
+        VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, $5, $6, $7, $8, $9, $10)
+        RETURNING id, created_at, updated_at`
+
+func insertSale(q queryRower, sale *models.Sale) error {
+	err := q.QueryRow(
+		insertSaleQuery,
+		sale.Title,
+		sale.Category,
+		sale.PreviewStart,
+		sale.StartTime,
+		sale.EndTime,
+		sale.TotalItems,
+		sale.SoldItems,
+		sale.IsActive,
+		sale.MaxItemsPerUser,
+		sale.DurationSeconds,
+	).Scan(&sale.ID, &sale.CreatedAt, &sale.UpdatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDBActiveSaleExists
+		}
+		return fmt.Errorf("failed to create sale: %w", err)
+	}
+	return nil
+}
+
+// ReplaceActiveSale deactivates the active sales and inserts sale in their
+// place, in one transaction, so no moment passes between the two. It
+// returns the created sale and the IDs of the sales it deactivated. A
+// concurrent replacement that commits first makes it fail with
+// ErrDBActiveSaleExists.
+func (s *DBStore) ReplaceActiveSale(sale *models.Sale) (*models.Sale, []int64, error) {
+	var deactivated []int64
+	err := s.withTx(context.Background(), sql.LevelDefault, func(tx *sql.Tx) error {
+		rows, err := tx.Query(`UPDATE sales SET is_active = FALSE WHERE is_active = TRUE RETURNING id`)
+		if err != nil {
+			return fmt.Errorf("failed to deactivate active sales: %w", err)
+		}
+		if deactivated, err = scanIDs(rows); err != nil {
+			return err
+		}
+		return insertSale(tx, sale)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return sale, deactivated, nil
+}
+
+// CreatePreparedSale inserts an inactive sale marked as prepared, to be
+// switched on later by ActivatePreparedSale.
+func (s *DBStore) CreatePreparedSale(sale *models.Sale) (*models.Sale, error) {
 	query := `
-        INSERT INTO sales (start_time, end_time, total_items, sold_items, is_active)
-        VALUES ($1, $2, $3, $4, $5)
+        INSERT INTO sales (title, category, start_time, end_time, total_items, sold_items, is_active, is_prepared, max_items_per_user, duration_seconds)
+        VALUES (NULLIF($1, ''), NULLIF($2, ''), $3, $4, $5, 0, FALSE, TRUE, $6, $7)
         RETURNING id, created_at, updated_at`
 
 	err := s.DB.QueryRow(
 		query,
+		sale.Title,
+		sale.Category,
 		sale.StartTime,
 		sale.EndTime,
 		sale.TotalItems,
-		sale.SoldItems,
-		sale.IsActive,
+		sale.MaxItemsPerUser,
+		sale.DurationSeconds,
 	).Scan(&sale.ID, &sale.CreatedAt, &sale.UpdatedAt)
 
 	if err != nil {
-		return nil, fmt.Errorf("failed to create sale: %w", err)
+		if isUniqueViolation(err) {
+			return nil, ErrDBPreparedSaleExists
+		}
+		return nil, fmt.Errorf("failed to create prepared sale: %w", err)
 	}
+	sale.IsActive = false
 	return sale, nil
 }
 
-func (s *DBStore) CreateItemsBatch(items []models.Item) ([]models.Item, error) {
-	if len(items) == 0 {
-		return nil, fmt.Errorf("no items to create")
+// GetPreparedSale returns the prepared sale, or nil if there is none.
+func (s *DBStore) GetPreparedSale() (*models.Sale, error) {
+	query := `
+        SELECT ` + saleColumns + `
+        FROM sales
+        WHERE is_prepared = TRUE`
+	sale, err := scanSale(s.DB.QueryRow(query))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get prepared sale: %w", err)
+	}
+	return sale, nil
+}
+
+// CountItemsForSale returns how many items exist for a sale, sold or not.
+func (s *DBStore) CountItemsForSale(saleID int64) (int, error) {
+	var count int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM items WHERE sale_id = $1`, saleID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count items for sale: %w", err)
+	}
+	return count, nil
+}
+
+// DeletePreparedSale removes a prepared sale and, through the foreign key
+// cascade, its items. Sales that are not prepared are left alone.
+func (s *DBStore) DeletePreparedSale(saleID int64) error {
+	_, err := s.DB.Exec(`DELETE FROM sales WHERE id = $1 AND is_prepared = TRUE`, saleID)
+	if err != nil {
+		return fmt.Errorf("failed to delete prepared sale: %w", err)
 	}
+	return nil
+}
 
+// ActivatePreparedSale deactivates the active sales and switches the prepared
+// sale on with the given times, in one transaction. A sale with its own
+// duration_seconds ends that long after it opens instead of at endTime. A
+// prepared sale whose items are not all in place is not activated. It returns
+// the activated sale, or nil if there was none, and the IDs of the sales it
+// deactivated.
+func (s *DBStore) ActivatePreparedSale(previewStart *time.Time, startTime, endTime time.Time) (*models.Sale, []int64, error) {
 	tx, err := s.DB.Begin()
 	if err != nil {
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare(`
-        INSERT INTO items (sale_id, name, image_url, is_sold)
-        VALUES ($1, $2, $3, $4)
-        RETURNING id, created_at, updated_at`)
+	var saleID int64
+	var durationSeconds sql.NullInt64
+	err = tx.QueryRow(`
+        SELECT id, duration_seconds FROM sales
+        WHERE is_prepared = TRUE
+          AND total_items = (SELECT COUNT(*) FROM items WHERE items.sale_id = sales.id)
+        FOR UPDATE`).Scan(&saleID, &durationSeconds)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to lock prepared sale: %w", err)
+	}
+	if durationSeconds.Valid {
+		opensAt := startTime
+		if previewStart != nil {
+			opensAt = *previewStart
+		}
+		endTime = opensAt.Add(time.Duration(durationSeconds.Int64) * time.Second)
+	}
+
+	rows, err := tx.Query(`UPDATE sales SET is_active = FALSE WHERE is_active = TRUE RETURNING id`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to deactivate active sales: %w", err)
+	}
+	deactivated, err := scanIDs(rows)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sale, err := scanSale(tx.QueryRow(`
+        UPDATE sales
+        SET is_active = TRUE, is_prepared = FALSE, preview_start = $2, start_time = $3, end_time = $4, updated_at = NOW()
+        WHERE id = $1
+        RETURNING `+saleColumns, saleID, previewStart, startTime, endTime))
 	if err != nil {
-		return nil, fmt.Errorf("failed to prepare statement: %w", err)
+		return nil, nil, fmt.Errorf("failed to activate prepared sale: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return sale, deactivated, nil
+}
+
+// ItemBatchError reports which item of a batch failed to insert.
+type ItemBatchError struct {
+	Index int
+	Err   error
+}
+
+func (e *ItemBatchError) Error() string {
+	return fmt.Sprintf("failed to insert item %d: %v", e.Index, e.Err)
+}
+
+func (e *ItemBatchError) Unwrap() error {
+	return e.Err
+}
+
+// CreateItemsBatch inserts the items in one transaction, so either all of
+// them exist afterwards or none do. A failing insert is reported as an
+// *ItemBatchError.
+func (s *DBStore) CreateItemsBatch(items []models.Item) ([]models.Item, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no items to create")
 	}
-	defer stmt.Close()
 
 	createdItems := make([]models.Item, len(items))
-	for i, item := range items {
-		err := stmt.QueryRow(item.SaleID, item.Name, item.ImageURL, item.IsSold).Scan(
-			&createdItems[i].ID, &createdItems[i].CreatedAt, &createdItems[i].UpdatedAt)
+	err := s.withTx(context.Background(), sql.LevelDefault, func(tx *sql.Tx) error {
+		stmt, err := tx.Prepare(`
+            INSERT INTO items (sale_id, name, image_url, price_cents, currency, sku, is_sold)
+            VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)
+            RETURNING id, created_at, updated_at`)
 		if err != nil {
-			return nil, fmt.Errorf("failed to insert item %d: %w", i, err)
+			return fmt.Errorf("failed to prepare statement: %w", err)
 		}
-		createdItems[i].SaleID = item.SaleID
-		createdItems[i].Name = item.Name
-		createdItems[i].ImageURL = item.ImageURL
-		createdItems[i].IsSold = item.IsSold
-	}
+		defer stmt.Close()
 
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		for i, item := range items {
+			err := stmt.QueryRow(item.SaleID, item.Name, item.ImageURL, item.PriceCents, item.Currency, item.SKU, item.IsSold).Scan(
+				&createdItems[i].ID, &createdItems[i].CreatedAt, &createdItems[i].UpdatedAt)
+			if err != nil {
+				return &ItemBatchError{Index: i, Err: err}
+			}
+			createdItems[i].SaleID = item.SaleID
+			createdItems[i].Name = item.Name
+			createdItems[i].ImageURL = item.ImageURL
+			createdItems[i].PriceCents = item.PriceCents
+			createdItems[i].Currency = item.Currency
+			createdItems[i].SKU = item.SKU
+			createdItems[i].IsSold = item.IsSold
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return createdItems, nil
 }
 
-func (s *DBStore) GetActiveSale() (*models.Sale, error) {
+// GetLatestSale returns the most recently started sale, active or not,
+// ignoring a prepared one, or nil if there has never been a sale.
+func (s *DBStore) GetLatestSale() (*models.Sale, error) {
 	query := `
-        SELECT id, start_time, end_time, total_items, sold_items, is_active, created_at, updated_at
+        SELECT ` + saleColumns + `
         FROM sales
-        WHERE is_active = TRUE AND NOW() BETWEEN start_time AND end_time
+        WHERE is_prepared = FALSE
         ORDER BY start_time DESC
         LIMIT 1`
 
-	sale := &models.Sale{}
-	err := s.DB.QueryRow(query).Scan(
-		&sale.ID,
-		&sale.StartTime,
-		&sale.EndTime,
-		&sale.TotalItems,
-		&sale.SoldItems,
-		&sale.IsActive,
-		&sale.CreatedAt,
-		&sale.UpdatedAt,
-	)
+	sale, err := scanSale(s.DB.QueryRow(query))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get latest sale: %w", err)
+	}
+	return sale, nil
+}
+
+func (s *DBStore) GetActiveSale() (*models.Sale, error) {
+	query := `
+        SELECT ` + saleColumns + `
+        FROM sales
+        WHERE is_active = TRUE AND NOW() BETWEEN COALESCE(preview_start, start_time) AND end_time
+        ORDER BY start_time DESC
+        LIMIT 1`
 
+	sale, err := scanSale(s.DB.QueryRow(query))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -185,23 +593,38 @@ func (s *DBStore) GetActiveSale() (*models.Sale, error) {
 	return sale, nil
 }
 
-func (s *DBStore) GetItemForCheckout(itemID int64, saleID int64) (*models.Item, error) {
-	query := `
-        SELECT id, sale_id, name, image_url, is_sold, created_at, updated_at
-        FROM items
-        WHERE id = $1 AND sale_id = $2 AND is_sold = FALSE`
+const itemColumns = `id, sale_id, name, image_url, price_cents, currency, sku, is_sold, is_disabled, created_at, updated_at`
 
+func scanItem(row rowScanner) (*models.Item, error) {
 	item := &models.Item{}
-	err := s.DB.QueryRow(query, itemID, saleID).Scan(
+	var sku sql.NullString
+	err := row.Scan(
 		&item.ID,
 		&item.SaleID,
 		&item.Name,
 		&item.ImageURL,
+		&item.PriceCents,
+		&item.Currency,
+		&sku,
 		&item.IsSold,
+		&item.IsDisabled,
 		&item.CreatedAt,
 		&item.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	item.SKU = sku.String
+	return item, nil
+}
 
+func (s *DBStore) GetItemForCheckout(itemID int64, saleID int64) (*models.Item, error) {
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items
+        WHERE id = $1 AND sale_id = $2 AND is_sold = FALSE AND is_disabled = FALSE`
+
+	item, err := scanItem(s.DB.QueryRow(query, itemID, saleID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -211,146 +634,893 @@ func (s *DBStore) GetItemForCheckout(itemID int64, saleID int64) (*models.Item,
 	return item, nil
 }
 
-func (s *DBStore) GetUserPurchaseCountForSale(userID string, saleID int64) (int, error) {
+// CheckoutEligibility is what CheckCheckoutEligibility found about a user
+// checking out an item of the active sale.
+type CheckoutEligibility struct {
+	// Sale is the active sale, nil when there is none; the other fields are
+	// then zero.
+	Sale *models.Sale
+	// ItemAvailable reports whether the item belongs to Sale and is neither
+	// sold nor disabled.
+	ItemAvailable bool
+	// ItemSaleID is the sale the item belongs to, whether or not it is
+	// Sale, and zero when the item does not exist.
+	ItemSaleID int64
+	// ItemSold reports whether the item has been sold.
+	ItemSold bool
+	// UserPurchases is how many items the user has bought in Sale.
+	UserPurchases int
+}
+
+// CheckCheckoutEligibility reads the active sale, the item's sale and state,
+// and the user's purchase count in the sale, in one query, so the checkout
+// path needs a single round trip where GetActiveSale, GetItemByID and
+// GetUserPurchaseCountForSale would take three.
+func (s *DBStore) CheckCheckoutEligibility(userID string, itemID int64) (*CheckoutEligibility, error) {
 	query := `
-        SELECT items_purchased
-        FROM user_sale_limits
-        WHERE user_id = $1 AND sale_id = $2`
+        SELECT ` + qualifyColumns("s", saleColumns) + `,
+               COALESCE(i.sale_id, 0),
+               COALESCE(i.is_sold, FALSE),
+               COALESCE(i.is_disabled, FALSE),
+               COALESCE(l.items_purchased, 0)
+        FROM sales s
+        LEFT JOIN items i ON i.id = $2
+        LEFT JOIN user_sale_limits l ON l.user_id = $1 AND l.sale_id = s.id
+        WHERE s.is_active = TRUE AND NOW() BETWEEN COALESCE(s.preview_start, s.start_time) AND s.end_time
+        ORDER BY s.start_time DESC
+        LIMIT 1`
 
-	var count int
-	err := s.DB.QueryRow(query, userID, saleID).Scan(&count)
+	e := &CheckoutEligibility{}
+	var itemSaleID int64
+	var itemSold, itemDisabled bool
+	var userPurchases int
+	row := extraColumns{s.DB.QueryRow(query, userID, itemID), []any{&itemSaleID, &itemSold, &itemDisabled, &userPurchases}}
+	sale, err := scanSale(row)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return 0, nil
+			return e, nil
 		}
-		return 0, fmt.Errorf("failed to get user purchase count: %w", err)
+		return nil, fmt.Errorf("failed to check checkout eligibility: %w", err)
 	}
-	return count, nil
+
+	e.Sale = sale
+	e.ItemAvailable = itemSaleID == sale.ID && !itemSold && !itemDisabled
+	e.ItemSaleID = itemSaleID
+	e.ItemSold = itemSold
+	e.UserPurchases = userPurchases
+	return e, nil
 }
 
-func (s *DBStore) CreateCheckoutAttempt(attempt *models.CheckoutAttempt) error {
-	query := `
-        INSERT INTO checkout_attempts (id, user_id, item_id, sale_id, expires_at, is_used, created_at)
-        VALUES ($1, $2, $3, $4, $5, $6, NOW())
-        RETURNING created_at`
+// qualifyColumns prefixes each column of a comma-separated list with alias.
+func qualifyColumns(alias, columns string) string {
+	names := strings.Split(columns, ", ")
+	for i, name := range names {
+		names[i] = alias + "." + name
+	}
+	return strings.Join(names, ", ")
+}
 
-	err := s.DB.QueryRow(
-		query,
-		attempt.ID,
-		attempt.UserID,
-		attempt.ItemID,
-		attempt.SaleID,
-		attempt.ExpiresAt,
-		attempt.IsUsed,
-	).Scan(&attempt.CreatedAt)
+// extraColumns lets a scan function for a fixed column list read a row that
+// carries further columns after them, into extra.
+type extraColumns struct {
+	row   rowScanner
+	extra []any
+}
 
-	if err != nil {
-		return fmt.Errorf("failed to create checkout attempt: %w", err)
-	}
-	return nil
+func (r extraColumns) Scan(dest ...any) error {
+	return r.row.Scan(append(dest, r.extra...)...)
 }
 
-func (s *DBStore) GetCheckoutAttemptByID(code string) (*models.CheckoutAttempt, error) {
+func (s *DBStore) GetItemByID(itemID int64) (*models.Item, error) {
 	query := `
-        SELECT id, user_id, item_id, sale_id, expires_at, is_used, created_at
-        FROM checkout_attempts
+        SELECT ` + itemColumns + `
+        FROM items
         WHERE id = $1`
-	attempt := &models.CheckoutAttempt{}
-	err := s.DB.QueryRow(query, code).Scan(
-		&attempt.ID,
-		&attempt.UserID,
-		&attempt.ItemID,
-		&attempt.SaleID,
-		&attempt.ExpiresAt,
-		&attempt.IsUsed,
-		&attempt.CreatedAt,
-	)
+
+	item, err := scanItem(s.DB.QueryRow(query, itemID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
-		return nil, fmt.Errorf("failed to get checkout attempt: %w", err)
+		return nil, fmt.Errorf("failed to get item by ID: %w", err)
 	}
-	return attempt, nil
+	return item, nil
 }
 
-func (s *DBStore) GetSaleByID(saleID int64) (*models.Sale, error) {
-	query := `
-        SELECT id, start_time, end_time, total_items, sold_items, is_active, created_at, updated_at
-        FROM sales
-        WHERE id = $1`
-	sale := &models.Sale{}
-	err := s.DB.QueryRow(query, saleID).Scan(
-		&sale.ID, &sale.StartTime, &sale.EndTime, &sale.TotalItems,
-		&sale.SoldItems, &sale.IsActive, &sale.CreatedAt, &sale.UpdatedAt,
-	)
+// DisableItem pulls an item from listings and new checkouts. Reservations
+// already made for it can still be purchased. It reports whether the item
+// exists.
+func (s *DBStore) DisableItem(itemID int64) (bool, error) {
+	res, err := s.DB.Exec(`UPDATE items SET is_disabled = TRUE WHERE id = $1`, itemID)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to get sale by ID: %w", err)
+		return false, fmt.Errorf("failed to disable item: %w", err)
 	}
-	return sale, nil
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to disable item: %w", err)
+	}
+	return n > 0, nil
+}
+
+// HasAvailableItems reports whether the sale has any item left that is
+// neither sold nor disabled.
+func (s *DBStore) HasAvailableItems(saleID int64) (bool, error) {
+	var available bool
+	err := s.DB.QueryRow(`
+        SELECT EXISTS (SELECT 1 FROM items WHERE sale_id = $1 AND is_sold = FALSE AND is_disabled = FALSE)`,
+		saleID).Scan(&available)
+	if err != nil {
+		return false, fmt.Errorf("failed to check available items: %w", err)
+	}
+	return available, nil
+}
+
+// ListAvailableItemIDs returns the IDs of every unsold, enabled item in the
+// sale.
+func (s *DBStore) ListAvailableItemIDs(saleID int64) ([]int64, error) {
+	rows, err := s.DB.Query(`SELECT id FROM items WHERE sale_id = $1 AND is_sold = FALSE AND is_disabled = FALSE`, saleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list available item IDs: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+// ListExpiredCheckoutItemIDs returns the sale's available items whose
+// checkout codes expired unused within the last expiredWithin and that no
+// open checkout attempt holds now.
+func (s *DBStore) ListExpiredCheckoutItemIDs(saleID int64, expiredWithin time.Duration) ([]int64, error) {
+	rows, err := s.DB.Query(`
+        SELECT DISTINCT i.id
+        FROM checkout_attempts ca
+        JOIN items i ON i.id = ca.item_id
+        WHERE ca.sale_id = $1 AND ca.is_used = FALSE
+          AND ca.expires_at <= NOW() AND ca.expires_at > NOW() - $2 * INTERVAL '1 millisecond'
+          AND i.is_sold = FALSE AND i.is_disabled = FALSE
+          AND NOT EXISTS (
+              SELECT 1 FROM checkout_attempts open
+              WHERE open.item_id = i.id AND open.is_used = FALSE AND open.expires_at > NOW()
+          )`, saleID, expiredWithin.Milliseconds())
+	if err != nil {
+		return nil, fmt.Errorf("failed to list items of expired checkouts: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+// Sort orders for ListUnsoldItems and SearchUnsoldItems.
+const (
+	ItemSortID     = "id"
+	ItemSortName   = "name"
+	ItemSortRandom = "random"
+)
+
+// itemOrderClauses maps each sort order to a fixed ORDER BY clause, so the
+// client's choice never reaches the SQL text.
+var itemOrderClauses = map[string]string{
+	ItemSortID:     "id",
+	ItemSortName:   "name, id",
+	ItemSortRandom: "random()",
+}
+
+// IsItemSort reports whether sort is a supported item sort order.
+func IsItemSort(sort string) bool {
+	_, ok := itemOrderClauses[sort]
+	return ok
+}
+
+// ListUnsoldItems lists unsold items of a sale in the given sort order,
+// starting after the item with ID afterID (zero for the first page) and
+// skipping offset more. Paging by afterID keeps deep pages as cheap as the
+// first one but only makes sense in ID order.
+func (s *DBStore) ListUnsoldItems(saleID, afterID int64, limit, offset int, sort string) ([]models.Item, error) {
+	orderBy, ok := itemOrderClauses[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown item sort %q", sort)
+	}
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items
+        WHERE sale_id = $1 AND is_sold = FALSE AND is_disabled = FALSE AND id > $2
+        ORDER BY ` + orderBy + `
+        LIMIT $3 OFFSET $4`
+
+	rows, err := s.DB.Query(query, saleID, afterID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unsold items: %w", err)
+	}
+	return scanItems(rows)
+}
+
+// GetItemsForSale lists a sale's items in ID order, all of them when sold
+// is nil, otherwise only the sold or only the unsold ones. Disabled items
+// are included.
+func (s *DBStore) GetItemsForSale(saleID int64, sold *bool, limit, offset int) ([]models.Item, error) {
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items
+        WHERE sale_id = $1 AND ($2::boolean IS NULL OR is_sold = $2)
+        ORDER BY id
+        LIMIT $3 OFFSET $4`
+
+	rows, err := s.DB.Query(query, saleID, sold, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sale items: %w", err)
+	}
+	return scanItems(rows)
+}
+
+// SearchUnsoldItems lists unsold items of a sale whose name contains the
+// given text, case-insensitively. LIKE wildcards in the text match literally.
+// Sorting and paging work as in ListUnsoldItems.
+func (s *DBStore) SearchUnsoldItems(saleID int64, query string, afterID int64, limit, offset int, sort string) ([]models.Item, error) {
+	orderBy, ok := itemOrderClauses[sort]
+	if !ok {
+		return nil, fmt.Errorf("unknown item sort %q", sort)
+	}
+	sqlQuery := `
+        SELECT ` + itemColumns + `
+        FROM items
+        WHERE sale_id = $1 AND is_sold = FALSE AND is_disabled = FALSE AND name ILIKE $2 AND id > $3
+        ORDER BY ` + orderBy + `
+        LIMIT $4 OFFSET $5`
+
+	pattern := "%" + escapeLikePattern(query) + "%"
+	rows, err := s.DB.Query(sqlQuery, saleID, pattern, afterID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search unsold items: %w", err)
+	}
+	return scanItems(rows)
+}
+
+func scanItems(rows *sql.Rows) ([]models.Item, error) {
+	defer rows.Close()
+
+	items := []models.Item{}
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan item: %w", err)
+		}
+		items = append(items, *item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate items: %w", err)
+	}
+	return items, nil
+}
+
+func escapeLikePattern(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func (s *DBStore) GetUserPurchaseCountForSale(userID string, saleID int64) (int, error) {
+	query := `
+        SELECT items_purchased
+        FROM user_sale_limits
+        WHERE user_id = $1 AND sale_id = $2`
+
+	var count int
+	err := s.DB.QueryRow(query, userID, saleID).Scan(&count)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, fmt.Errorf("failed to get user purchase count: %w", err)
+	}
+	return count, nil
+}
+
+// GetUserSales returns the sales the user bought items in, newest first.
+func (s *DBStore) GetUserSales(userID string) ([]models.UserSale, error) {
+	rows, err := s.DB.Query(`
+        SELECT s.id, COALESCE(s.title, ''), COALESCE(s.category, ''), s.start_time, s.end_time, l.items_purchased
+        FROM user_sale_limits l
+        JOIN sales s ON s.id = l.sale_id
+        WHERE l.user_id = $1 AND l.items_purchased > 0
+        ORDER BY s.start_time DESC, s.id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query user sales: %w", err)
+	}
+	defer rows.Close()
+
+	sales := []models.UserSale{}
+	for rows.Next() {
+		var sale models.UserSale
+		if err := rows.Scan(&sale.SaleID, &sale.Title, &sale.Category, &sale.StartTime, &sale.EndTime, &sale.ItemsPurchased); err != nil {
+			return nil, fmt.Errorf("failed to scan user sale: %w", err)
+		}
+		sales = append(sales, sale)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate user sales: %w", err)
+	}
+	return sales, nil
+}
+
+// HasUserPurchasedItem reports whether the user bought the item in the sale.
+func (s *DBStore) HasUserPurchasedItem(userID string, itemID, saleID int64) (bool, error) {
+	var purchased bool
+	err := s.DB.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM purchases WHERE user_id = $1 AND item_id = $2 AND sale_id = $3
+        )`, userID, itemID, saleID).Scan(&purchased)
+	if err != nil {
+		return false, fmt.Errorf("failed to check user purchase of item: %w", err)
+	}
+	return purchased, nil
+}
+
+// GetUserItemsForSale lists the items the user has bought in the sale, in
+// purchase order.
+func (s *DBStore) GetUserItemsForSale(userID string, saleID int64) ([]models.Item, error) {
+	query := `
+        SELECT i.id, i.sale_id, i.name, i.image_url, i.price_cents, i.currency, i.sku, i.is_sold, i.is_disabled, i.created_at, i.updated_at
+        FROM purchases p
+        JOIN items i ON i.id = p.item_id
+        WHERE p.user_id = $1 AND p.sale_id = $2
+        ORDER BY p.purchased_at, p.id`
+
+	rows, err := s.DB.Query(query, userID, saleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user items for sale: %w", err)
+	}
+	return scanItems(rows)
+}
+
+// GetUserTotalPurchases counts the user's purchases across all sales.
+func (s *DBStore) GetUserTotalPurchases(userID string) (int, error) {
+	var count int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM purchases WHERE user_id = $1`, userID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get user total purchases: %w", err)
+	}
+	return count, nil
+}
+
+// CountPurchasesSince returns how many items the user has bought across all
+// sales since the given time.
+func (s *DBStore) CountPurchasesSince(userID string, since time.Time) (int, error) {
+	var count int
+	err := s.DB.QueryRow(`SELECT COUNT(*) FROM purchases WHERE user_id = $1 AND purchased_at >= $2`, userID, since).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recent purchases: %w", err)
+	}
+	return count, nil
+}
+
+// CountActiveCheckoutAttempts counts the user's unused, unexpired checkout
+// codes in the sale.
+func (s *DBStore) CountActiveCheckoutAttempts(userID string, saleID int64) (int, error) {
+	query := `
+        SELECT COUNT(*)
+        FROM checkout_attempts
+        WHERE user_id = $1 AND sale_id = $2 AND is_used = FALSE AND expires_at > NOW()`
+
+	var count int
+	if err := s.DB.QueryRow(query, userID, saleID).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count active checkout attempts: %w", err)
+	}
+	return count, nil
+}
+
+// GetOpenCheckoutAttempt returns the user's unused checkout attempt for the
+// item that expires last, if it is still valid until minExpiresAt, or nil.
+func (s *DBStore) GetOpenCheckoutAttempt(userID string, itemID, saleID int64, minExpiresAt time.Time) (*models.CheckoutAttempt, error) {
+	query := `
+        SELECT id, user_id, item_id, sale_id, expires_at, is_used, created_at
+        FROM checkout_attempts
+        WHERE user_id = $1 AND sale_id = $2 AND item_id = $3 AND is_used = FALSE AND expires_at > $4
+        ORDER BY expires_at DESC
+        LIMIT 1`
+	attempt := &models.CheckoutAttempt{}
+	err := s.DB.QueryRow(query, userID, saleID, itemID, minExpiresAt).Scan(
+		&attempt.ID,
+		&attempt.UserID,
+		&attempt.ItemID,
+		&attempt.SaleID,
+		&attempt.ExpiresAt,
+		&attempt.IsUsed,
+		&attempt.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get open checkout attempt: %w", err)
+	}
+	return attempt, nil
+}
+
+const insertCheckoutAttemptQuery = `
+        INSERT INTO checkout_attempts (id, user_id, item_id, sale_id, expires_at, is_used, client_ip, user_agent, created_at)
+        VALUES ($1, $2, $3, $4, $5, $6, NULLIF($7, ''), NULLIF($8, ''), NOW())
+        RETURNING created_at`
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
 }
 
-func (s *DBStore) ExecutePurchaseTransaction(userID string, itemID int64, saleID int64, checkoutCode string, userItemLimitPerSale int) (*models.Item, error) {
-	tx, err := s.DB.BeginTx(context.Background(), nil)
+func insertCheckoutAttempt(q queryRower, attempt *models.CheckoutAttempt) error {
+	err := q.QueryRow(
+		insertCheckoutAttemptQuery,
+		attempt.ID,
+		attempt.UserID,
+		attempt.ItemID,
+		attempt.SaleID,
+		attempt.ExpiresAt,
+		attempt.IsUsed,
+		attempt.ClientIP,
+		attempt.UserAgent,
+	).Scan(&attempt.CreatedAt)
+
+	if err != nil {
+		if isUniqueViolation(err) {
+			return ErrDBDuplicateCheckoutCode
+		}
+		return fmt.Errorf("failed to create checkout attempt: %w", err)
+	}
+	return nil
+}
+
+func (s *DBStore) CreateCheckoutAttempt(attempt *models.CheckoutAttempt) error {
+	return insertCheckoutAttempt(s.DB, attempt)
+}
+
+// ClaimRandomUnsoldItem picks a random item of attempt's sale that is unsold,
+// enabled, and not held by an open checkout attempt, and records attempt for
+// it in the same transaction. FOR UPDATE SKIP LOCKED makes concurrent callers
+// pass over each other's picks instead of waiting on them, and the attempt
+// row keeps the item claimed once the transaction commits. attempt.ItemID is
+// set to the claimed item. It returns ErrDBNoItemAvailable when every item
+// is sold, disabled or claimed.
+func (s *DBStore) ClaimRandomUnsoldItem(attempt *models.CheckoutAttempt) (*models.Item, error) {
+	tx, err := s.DB.Begin()
 	if err != nil {
 		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	var item models.Item
-	itemQuery := `SELECT id, sale_id, name, image_url, is_sold FROM items WHERE id = $1 AND sale_id = $2 FOR UPDATE`
-	err = tx.QueryRow(itemQuery, itemID, saleID).Scan(&item.ID, &item.SaleID, &item.Name, &item.ImageURL, &item.IsSold)
+	query := `
+        SELECT ` + itemColumns + `
+        FROM items
+        WHERE sale_id = $1 AND is_sold = FALSE AND is_disabled = FALSE
+          AND NOT EXISTS (
+              SELECT 1 FROM checkout_attempts ca
+              WHERE ca.item_id = items.id AND ca.is_used = FALSE AND ca.expires_at > NOW()
+          )
+        ORDER BY random()
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED`
+	item, err := scanItem(tx.QueryRow(query, attempt.SaleID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrDBNoItemAvailable
+		}
+		return nil, fmt.Errorf("failed to claim random item: %w", err)
+	}
+
+	attempt.ItemID = item.ID
+	if err := insertCheckoutAttempt(tx, attempt); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return item, nil
+}
+
+func (s *DBStore) GetCheckoutAttemptByID(code string) (*models.CheckoutAttempt, error) {
+	query := `
+        SELECT id, user_id, item_id, sale_id, expires_at, is_used, created_at
+        FROM checkout_attempts
+        WHERE id = $1`
+	attempt := &models.CheckoutAttempt{}
+	err := s.DB.QueryRow(query, code).Scan(
+		&attempt.ID,
+		&attempt.UserID,
+		&attempt.ItemID,
+		&attempt.SaleID,
+		&attempt.ExpiresAt,
+		&attempt.IsUsed,
+		&attempt.CreatedAt,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get checkout attempt: %w", err)
+	}
+	return attempt, nil
+}
+
+// ItemHasOpenCheckout reports whether an unused, unexpired checkout code
+// holds the item.
+func (s *DBStore) ItemHasOpenCheckout(itemID int64) (bool, error) {
+	var open bool
+	err := s.DB.QueryRow(`
+        SELECT EXISTS (
+            SELECT 1 FROM checkout_attempts
+            WHERE item_id = $1 AND is_used = FALSE AND expires_at > NOW()
+        )`, itemID).Scan(&open)
+	if err != nil {
+		return false, fmt.Errorf("failed to check open checkouts of item: %w", err)
+	}
+	return open, nil
+}
+
+// ExpireUserCheckoutAttempts invalidates every outstanding checkout code the
+// user holds in the sale and returns the codes it expired.
+func (s *DBStore) ExpireUserCheckoutAttempts(userID string, saleID int64) ([]string, error) {
+	query := `
+        UPDATE checkout_attempts
+        SET expires_at = NOW()
+        WHERE user_id = $1 AND sale_id = $2 AND is_used = FALSE AND expires_at > NOW()
+        RETURNING id`
+
+	rows, err := s.DB.Query(query, userID, saleID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire user checkout attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var codes []string
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, fmt.Errorf("failed to scan expired checkout code: %w", err)
+		}
+		codes = append(codes, code)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate expired checkout codes: %w", err)
+	}
+	return codes, nil
+}
+
+// MarkOrphanedAttempts cancels the unused checkout codes of a sale that is
+// no longer active by expiring them now, so none can be redeemed against the
+// dead sale, and returns how many it cancelled. It does nothing while the
+// sale is active.
+func (s *DBStore) MarkOrphanedAttempts(saleID int64) (int64, error) {
+	res, err := s.DB.Exec(`
+        UPDATE checkout_attempts a
+        SET expires_at = NOW()
+        FROM sales s
+        WHERE s.id = a.sale_id AND a.sale_id = $1 AND NOT s.is_active
+          AND a.is_used = FALSE AND a.expires_at > NOW()`, saleID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to cancel orphaned checkout attempts: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count orphaned checkout attempts: %w", err)
+	}
+	return n, nil
+}
+
+// CountExpiredCheckoutAttempts counts the sale's checkout codes that expired
+// unused, whether they ran out or were cancelled by MarkOrphanedAttempts.
+func (s *DBStore) CountExpiredCheckoutAttempts(saleID int64) (int64, error) {
+	var count int64
+	err := s.DB.QueryRow(`
+        SELECT COUNT(*)
+        FROM checkout_attempts
+        WHERE sale_id = $1 AND is_used = FALSE AND expires_at <= NOW()`, saleID).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count expired checkout attempts: %w", err)
+	}
+	return count, nil
+}
+
+func (s *DBStore) CountCheckoutsByIP(saleID int64, limit int) ([]models.IPCheckoutCount, error) {
+	query := `
+        SELECT client_ip, COUNT(*), COUNT(DISTINCT user_id)
+        FROM checkout_attempts
+        WHERE sale_id = $1 AND client_ip IS NOT NULL
+        GROUP BY client_ip
+        ORDER BY COUNT(*) DESC, client_ip
+        LIMIT $2`
+
+	rows, err := s.DB.Query(query, saleID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count checkouts by IP: %w", err)
+	}
+	defer rows.Close()
+
+	counts := []models.IPCheckoutCount{}
+	for rows.Next() {
+		var c models.IPCheckoutCount
+		if err := rows.Scan(&c.ClientIP, &c.Checkouts, &c.DistinctUsers); err != nil {
+			return nil, fmt.Errorf("failed to scan checkout count by IP: %w", err)
+		}
+		counts = append(counts, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate checkout counts by IP: %w", err)
+	}
+	return counts, nil
+}
+
+func (s *DBStore) GetSaleByID(saleID int64) (*models.Sale, error) {
+	query := `
+        SELECT ` + saleColumns + `
+        FROM sales
+        WHERE id = $1`
+	sale, err := scanSale(s.DB.QueryRow(query, saleID))
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return nil, fmt.Errorf("item not found")
+			return nil, nil
 		}
-		return nil, fmt.Errorf("failed to lock item: %w", err)
+		return nil, fmt.Errorf("failed to get sale by ID: %w", err)
+	}
+	return sale, nil
+}
+
+// PurchaseParams describes the purchase to execute and the limits to enforce
+// while holding the transaction's locks.
+type PurchaseParams struct {
+	UserID           string
+	ItemID           int64
+	SaleID           int64
+	CheckoutCode     string
+	UserLimitPerSale int
+	// GlobalUserLimit caps the user's purchases across all sales; zero
+	// disables the check.
+	GlobalUserLimit int
+	// SKULimit caps the user's purchases of the item's SKU in the sale;
+	// zero, or an item without a SKU, disables the check.
+	SKULimit int
+	// Isolation is the transaction's isolation level. Stricter levels make
+	// conflicting purchases fail with a serialization error to be retried.
+	Isolation sql.IsolationLevel
+	// PaymentReference is the verified payment the purchase was made with;
+	// empty when the purchase carried none.
+	PaymentReference string
+}
+
+// ExecutePurchaseTransaction atomically sells the item and returns it along
+// with the number of items left unsold in the sale afterwards.
+func (s *DBStore) ExecutePurchaseTransaction(p PurchaseParams) (*models.Item, int, error) {
+	var item *models.Item
+	var remaining int
+	err := s.withTx(context.Background(), p.Isolation, func(tx *sql.Tx) error {
+		var err error
+		item, remaining, err = executePurchase(tx, p)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	item.IsSold = true
+	return item, remaining, nil
+}
+
+// PurchaseOutcome is the result of one purchase of a batch.
+type PurchaseOutcome struct {
+	Item *models.Item
+	// Remaining is the number of items left unsold in the sale after the
+	// purchase.
+	Remaining int
+	Err       error
+}
+
+// ExecutePurchaseBatch runs the purchases, all by one user, in a single
+// transaction. The user's limit row of each sale is locked up front and
+// held until commit. Each purchase runs under a savepoint, so one refused
+// for a business reason is rolled back on its own and reported in its
+// outcome while the others stand. Any other failure rolls back the whole
+// batch and is returned.
+func (s *DBStore) ExecutePurchaseBatch(ps []PurchaseParams, isolation sql.IsolationLevel) ([]PurchaseOutcome, error) {
+	var outcomes []PurchaseOutcome
+	err := s.withTx(context.Background(), isolation, func(tx *sql.Tx) error {
+		outcomes = make([]PurchaseOutcome, len(ps))
+		locked := make(map[int64]bool)
+		for _, p := range ps {
+			if locked[p.SaleID] {
+				continue
+			}
+			locked[p.SaleID] = true
+			if err := lockUserSaleLimit(tx, p.UserID, p.SaleID); err != nil {
+				return err
+			}
+		}
+
+		for i, p := range ps {
+			if _, err := tx.Exec(`SAVEPOINT purchase`); err != nil {
+				return fmt.Errorf("failed to create purchase savepoint: %w", err)
+			}
+			item, remaining, err := executePurchase(tx, p)
+			if err != nil {
+				if !isPurchaseRejection(err) {
+					return err
+				}
+				if _, err := tx.Exec(`ROLLBACK TO SAVEPOINT purchase`); err != nil {
+					return fmt.Errorf("failed to roll back purchase savepoint: %w", err)
+				}
+				outcomes[i].Err = err
+			} else {
+				item.IsSold = true
+				outcomes[i] = PurchaseOutcome{Item: item, Remaining: remaining}
+			}
+			if _, err := tx.Exec(`RELEASE SAVEPOINT purchase`); err != nil {
+				return fmt.Errorf("failed to release purchase savepoint: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return outcomes, nil
+}
+
+// lockUserSaleLimit locks the user's limit row of the sale, creating it
+// with no purchases if they have none yet.
+func lockUserSaleLimit(tx *sql.Tx, userID string, saleID int64) error {
+	_, err := tx.Exec(`
+        INSERT INTO user_sale_limits (user_id, sale_id, items_purchased)
+        VALUES ($1, $2, 0)
+        ON CONFLICT (user_id, sale_id) DO NOTHING`, userID, saleID)
+	if err != nil {
+		return fmt.Errorf("failed to create user sale limit: %w", err)
+	}
+	_, err = tx.Exec(`SELECT 1 FROM user_sale_limits WHERE user_id = $1 AND sale_id = $2 FOR UPDATE`, userID, saleID)
+	if err != nil {
+		return fmt.Errorf("failed to lock user sale limit: %w", err)
+	}
+	return nil
+}
+
+// isPurchaseRejection reports whether executePurchase refused the purchase
+// for a business reason rather than failing.
+func isPurchaseRejection(err error) bool {
+	for _, target := range []error{
+		ErrDBCheckoutAttemptNotFound,
+		ErrDBCheckoutCodeAlreadyUsed,
+		ErrDBCheckoutCodeExpired,
+		ErrDBItemAlreadySold,
+		ErrDBItemDisabled,
+		ErrDBSaleNotActive,
+		ErrDBSaleNotStarted,
+		ErrDBSaleLimitReached,
+		ErrDBUserPurchaseLimitReached,
+		ErrDBGlobalUserLimitReached,
+		ErrDBSKULimitReached,
+		ErrDBPaymentReferenceUsed,
+	} {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// executePurchase runs the checks and writes of a purchase inside tx.
+func executePurchase(tx *sql.Tx, p PurchaseParams) (*models.Item, int, error) {
+	// Locking the code first serializes concurrent purchases with the same
+	// code, so a replay fails as already used whatever any cache says. The
+	// database is authoritative: a code known only to Redis buys nothing, and
+	// one expired or released here stays expired even if Redis still has it.
+	var codeUsed, codeExpired bool
+	err := tx.QueryRow(`SELECT is_used, expires_at <= NOW() FROM checkout_attempts WHERE id = $1 FOR UPDATE`, p.CheckoutCode).Scan(&codeUsed, &codeExpired)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, 0, ErrDBCheckoutAttemptNotFound
+		}
+		return nil, 0, fmt.Errorf("failed to lock checkout code: %w", err)
+	}
+	if codeUsed {
+		return nil, 0, ErrDBCheckoutCodeAlreadyUsed
+	}
+	if codeExpired {
+		return nil, 0, ErrDBCheckoutCodeExpired
+	}
+
+	itemQuery := `SELECT ` + itemColumns + ` FROM items WHERE id = $1 AND sale_id = $2 FOR UPDATE`
+	item, err := scanItem(tx.QueryRow(itemQuery, p.ItemID, p.SaleID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, 0, fmt.Errorf("item not found")
+		}
+		return nil, 0, fmt.Errorf("failed to lock item: %w", err)
 	}
 	if item.IsSold {
-		return nil, ErrDBItemAlreadySold
+		return nil, 0, ErrDBItemAlreadySold
+	}
+	if item.IsDisabled {
+		return nil, 0, ErrDBItemDisabled
 	}
 
+	// The start- and end-time checks use the database clock, like
+	// GetActiveSale, so app clock skew cannot make a sale look open in one
+	// check and ended in another.
 	var currentSale models.Sale
-	saleQuery := `SELECT id, total_items, sold_items, is_active, end_time FROM sales WHERE id = $1 FOR UPDATE`
-	err = tx.QueryRow(saleQuery, saleID).Scan(&currentSale.ID, &currentSale.TotalItems, &currentSale.SoldItems, &currentSale.IsActive, &currentSale.EndTime)
+	var open, started bool
+	saleQuery := `SELECT id, total_items, sold_items, is_active AND NOW() <= end_time, NOW() >= start_time FROM sales WHERE id = $1 FOR UPDATE`
+	err = tx.QueryRow(saleQuery, p.SaleID).Scan(&currentSale.ID, &currentSale.TotalItems, &currentSale.SoldItems, &open, &started)
 	if err != nil {
-		return nil, fmt.Errorf("failed to lock sale: %w", err)
+		return nil, 0, fmt.Errorf("failed to lock sale: %w", err)
+	}
+	if !open {
+		return nil, 0, ErrDBSaleNotActive
 	}
-	if !currentSale.IsActive || time.Now().After(currentSale.EndTime) {
-		return nil, fmt.Errorf("sale is not active or has ended")
+	if !started {
+		return nil, 0, ErrDBSaleNotStarted
 	}
 	if currentSale.SoldItems >= currentSale.TotalItems {
-		return nil, ErrDBSaleLimitReached
+		return nil, 0, ErrDBSaleLimitReached
 	}
 
 	var userPurchaseCount int
 	userLimitQuery := `SELECT items_purchased FROM user_sale_limits WHERE user_id = $1 AND sale_id = $2 FOR UPDATE`
-	err = tx.QueryRow(userLimitQuery, userID, saleID).Scan(&userPurchaseCount)
+	err = tx.QueryRow(userLimitQuery, p.UserID, p.SaleID).Scan(&userPurchaseCount)
 	if err != nil && err != sql.ErrNoRows {
-		return nil, fmt.Errorf("failed to check user purchase limit: %w", err)
+		return nil, 0, fmt.Errorf("failed to check user purchase limit: %w", err)
 	}
-	if userPurchaseCount >= userItemLimitPerSale {
-		return nil, ErrDBUserPurchaseLimitReached
+	if userPurchaseCount >= p.UserLimitPerSale {
+		return nil, 0, ErrDBUserPurchaseLimitReached
 	}
 
-	_, err = tx.Exec(`UPDATE items SET is_sold = TRUE WHERE id = $1`, itemID)
+	if p.GlobalUserLimit > 0 {
+		// Purchases across sales have no single row to lock, so serialize the
+		// user's purchases with an advisory lock held until commit.
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, p.UserID); err != nil {
+			return nil, 0, fmt.Errorf("failed to lock user purchases: %w", err)
+		}
+		var totalPurchases int
+		if err := tx.QueryRow(`SELECT COUNT(*) FROM purchases WHERE user_id = $1`, p.UserID).Scan(&totalPurchases); err != nil {
+			return nil, 0, fmt.Errorf("failed to count user purchases: %w", err)
+		}
+		if totalPurchases >= p.GlobalUserLimit {
+			return nil, 0, ErrDBGlobalUserLimitReached
+		}
+	}
+
+	if p.SKULimit > 0 && item.SKU != "" {
+		// The user's limit row may not exist yet, so it cannot serialize
+		// their first purchases; take the same advisory lock as above.
+		if _, err := tx.Exec(`SELECT pg_advisory_xact_lock(hashtext($1))`, p.UserID); err != nil {
+			return nil, 0, fmt.Errorf("failed to lock user purchases: %w", err)
+		}
+		var skuPurchases int
+		err := tx.QueryRow(`
+            SELECT COUNT(*)
+            FROM purchases p
+            JOIN items i ON i.id = p.item_id
+            WHERE p.user_id = $1 AND p.sale_id = $2 AND i.sku = $3`, p.UserID, p.SaleID, item.SKU).Scan(&skuPurchases)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to count user purchases of SKU: %w", err)
+		}
+		if skuPurchases >= p.SKULimit {
+			return nil, 0, ErrDBSKULimitReached
+		}
+	}
+
+	_, err = tx.Exec(`UPDATE items SET is_sold = TRUE WHERE id = $1`, p.ItemID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to mark item as sold: %w", err)
+		return nil, 0, fmt.Errorf("failed to mark item as sold: %w", err)
 	}
 
-	_, err = tx.Exec(`UPDATE sales SET sold_items = sold_items + 1 WHERE id = $1`, saleID)
+	_, err = tx.Exec(`UPDATE sales SET sold_items = sold_items + 1 WHERE id = $1`, p.SaleID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to increment sale sold_items: %w", err)
+		return nil, 0, fmt.Errorf("failed to increment sale sold_items: %w", err)
 	}
 
 	_, err = tx.Exec(`
-        INSERT INTO purchases (user_id, item_id, sale_id, checkout_code, purchased_at)
-        VALUES ($1, $2, $3, $4, NOW())`, userID, itemID, saleID, checkoutCode)
+        INSERT INTO purchases (user_id, item_id, sale_id, checkout_code, item_name, payment_reference, purchased_at)
+        VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NOW())`,
+		p.UserID, p.ItemID, p.SaleID, p.CheckoutCode, item.Name, p.PaymentReference)
 	if err != nil {
-		return nil, fmt.Errorf("failed to record purchase: %w", err)
+		if violatesConstraint(err, purchasesItemUniqueIndex) {
+			return nil, 0, ErrDBItemAlreadySold
+		}
+		if violatesConstraint(err, purchasesPaymentReferenceUniqueIndex) {
+			return nil, 0, ErrDBPaymentReferenceUsed
+		}
+		if isUniqueViolation(err) {
+			return nil, 0, ErrDBCheckoutCodeAlreadyUsed
+		}
+		return nil, 0, fmt.Errorf("failed to record purchase: %w", err)
 	}
 
 	_, err = tx.Exec(`
@@ -358,33 +1528,437 @@ func (s *DBStore) ExecutePurchaseTransaction(userID string, itemID int64, saleID
         VALUES ($1, $2, 1)
         ON CONFLICT (user_id, sale_id)
         DO UPDATE SET items_purchased = user_sale_limits.items_purchased + 1
-        WHERE user_sale_limits.items_purchased < $3`, userID, saleID, userItemLimitPerSale)
+        WHERE user_sale_limits.items_purchased < $3`, p.UserID, p.SaleID, p.UserLimitPerSale)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to update user sale limits: %w", err)
+	}
+
+
+	_, err = tx.Exec(`UPDATE checkout_attempts SET is_used = TRUE WHERE id = $1`, p.CheckoutCode)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to mark checkout code as used: %w", err)
+	}
+
+	return item, currentSale.TotalItems - currentSale.SoldItems - 1, nil
+}
+
+// DeactivateAllActiveSales clears is_active on every active sale and returns
+// the IDs of the sales it deactivated.
+func (s *DBStore) DeactivateAllActiveSales() ([]int64, error) {
+	rows, err := s.DB.Query(`UPDATE sales SET is_active = FALSE WHERE is_active = TRUE RETURNING id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate all active sales: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+// DeactivateEndedSales clears is_active on sales past their end time and
+// returns the IDs of the sales it deactivated.
+func (s *DBStore) DeactivateEndedSales() ([]int64, error) {
+	rows, err := s.DB.Query(`UPDATE sales SET is_active = FALSE WHERE is_active = TRUE AND end_time < NOW() RETURNING id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deactivate ended sales: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// GetRecentPurchases returns the latest purchases of a sale, newest first.
+// Buyer holds the raw user ID; callers anonymize it before exposing it.
+func (s *DBStore) GetRecentPurchases(saleID int64, limit int) ([]models.RecentPurchase, error) {
+	query := `
+        SELECT p.item_id, COALESCE(p.item_name, i.name, ''), p.user_id, p.purchased_at
+        FROM purchases p
+        LEFT JOIN items i ON i.id = p.item_id
+        WHERE p.sale_id = $1
+        ORDER BY p.purchased_at DESC, p.id DESC
+        LIMIT $2`
+
+	rows, err := s.DB.Query(query, saleID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent purchases: %w", err)
+	}
+	defer rows.Close()
+
+	purchases := []models.RecentPurchase{}
+	for rows.Next() {
+		var p models.RecentPurchase
+		if err := rows.Scan(&p.ItemID, &p.ItemName, &p.Buyer, &p.PurchasedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan recent purchase: %w", err)
+		}
+		purchases = append(purchases, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate recent purchases: %w", err)
+	}
+	return purchases, nil
+}
+
+// GetSaleLeaderboard returns the sale's top buyers by items purchased, ties
+// broken by user ID so the order is stable. Buyers carry their raw user IDs.
+func (s *DBStore) GetSaleLeaderboard(saleID int64, limit int) ([]models.LeaderboardEntry, error) {
+	query := `
+        SELECT user_id, items_purchased
+        FROM user_sale_limits
+        WHERE sale_id = $1 AND items_purchased > 0
+        ORDER BY items_purchased DESC, user_id
+        LIMIT $2`
+
+	rows, err := s.DB.Query(query, saleID, limit)
 	if err != nil {
-		return nil, fmt.Errorf("failed to update user sale limits: %w", err)
+		return nil, fmt.Errorf("failed to get sale leaderboard: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.LeaderboardEntry{}
+	for rows.Next() {
+		entry := models.LeaderboardEntry{Rank: len(entries) + 1}
+		if err := rows.Scan(&entry.Buyer, &entry.ItemsPurchased); err != nil {
+			return nil, fmt.Errorf("failed to scan leaderboard entry: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate leaderboard: %w", err)
 	}
+	return entries, nil
+}
 
+// ReconcileSoldItems recomputes the sale's sold_items from its items and
+// returns the recorded and the counted values. The sale row is locked
+// first, as the purchase transaction does, so no purchase can land between
+// the count and the update.
+func (s *DBStore) ReconcileSoldItems(saleID int64) (recorded, counted int, err error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
 
-	_, err = tx.Exec(`UPDATE checkout_attempts SET is_used = TRUE WHERE id = $1`, checkoutCode)
+	err = tx.QueryRow(`SELECT sold_items FROM sales WHERE id = $1 FOR UPDATE`, saleID).Scan(&recorded)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to lock sale: %w", err)
+	}
+	err = tx.QueryRow(`SELECT COUNT(*) FROM items WHERE sale_id = $1 AND is_sold = TRUE`, saleID).Scan(&counted)
 	if err != nil {
-		return nil, fmt.Errorf("failed to mark checkout code as used: %w", err)
+		return 0, 0, fmt.Errorf("failed to count sold items: %w", err)
+	}
+	if recorded == counted {
+		return recorded, counted, nil
 	}
 
+	if _, err := tx.Exec(`UPDATE sales SET sold_items = $2 WHERE id = $1`, saleID, counted); err != nil {
+		return 0, 0, fmt.Errorf("failed to update sold_items: %w", err)
+	}
 	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+		return 0, 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
+	return recorded, counted, nil
+}
 
-	item.IsSold = true
-	return &item, nil
+// GetSaleSummary computes the end-of-sale figures for a sale: inventory sold,
+// distinct buyers, and the busiest minute of checkouts.
+func (s *DBStore) GetSaleSummary(saleID int64) (*models.SaleSummary, error) {
+	query := `
+        SELECT s.id, s.total_items, s.sold_items,
+            (SELECT COUNT(DISTINCT p.user_id) FROM purchases p WHERE p.sale_id = s.id),
+            COALESCE((
+                SELECT MAX(per_minute.checkouts)
+                FROM (
+                    SELECT COUNT(*) AS checkouts
+                    FROM checkout_attempts ca
+                    WHERE ca.sale_id = s.id
+                    GROUP BY date_trunc('minute', ca.created_at)
+                ) per_minute
+            ), 0)
+        FROM sales s
+        WHERE s.id = $1`
+
+	summary := &models.SaleSummary{}
+	err := s.DB.QueryRow(query, saleID).Scan(
+		&summary.SaleID,
+		&summary.TotalItems,
+		&summary.SoldItems,
+		&summary.UniqueBuyers,
+		&summary.PeakCheckoutsPerMinute,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sale summary: %w", err)
+	}
+	if summary.TotalItems > 0 {
+		summary.SellThroughPercent = float64(summary.SoldItems) * 100 / float64(summary.TotalItems)
+	}
+	return summary, nil
 }
 
-func (s *DBStore) DeactivateAllActiveSales() error {
-	_, err := s.DB.Exec(`UPDATE sales SET is_active = FALSE WHERE is_active = TRUE`)
+// SnapshotUnsoldItems copies the items a sale left unsold into the archive
+// and records their count on the sale, in one transaction. Running it again
+// for the same sale is harmless. It returns the number of unsold items.
+func (s *DBStore) SnapshotUnsoldItems(saleID int64) (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+        INSERT INTO unsold_items_archive (sale_id, item_id, name)
+        SELECT sale_id, id, name FROM items
+        WHERE sale_id = $1 AND is_sold = FALSE
+        ON CONFLICT (sale_id, item_id) DO NOTHING`, saleID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to archive unsold items: %w", err)
+	}
+
+	var count int
+	err = tx.QueryRow(`
+        UPDATE sales
+        SET unsold_items = (SELECT COUNT(*) FROM unsold_items_archive WHERE sale_id = $1)
+        WHERE id = $1
+        RETURNING unsold_items`, saleID).Scan(&count)
 	if err != nil {
-		return fmt.Errorf("failed to deactivate all active sales: %w", err)
+		return 0, fmt.Errorf("failed to record unsold item count: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return count, nil
+}
+
+// PurgeSale deletes the purchases, checkout attempts, user limits and items
+// of a finished sale in one transaction. The sale row and its unsold items
+// archive are kept, so the sale still shows up in reports. It returns nil if
+// the sale does not exist and ErrDBSaleNotFinished if it may still sell.
+func (s *DBStore) PurgeSale(saleID int64) (*models.SalePurge, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var finished bool
+	err = tx.QueryRow(`
+        SELECT NOT is_active AND NOT is_prepared AND end_time <= NOW()
+        FROM sales WHERE id = $1
+        FOR UPDATE`, saleID).Scan(&finished)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock sale: %w", err)
+	}
+	if !finished {
+		return nil, ErrDBSaleNotFinished
+	}
+
+	purge := &models.SalePurge{SaleID: saleID}
+	steps := []struct {
+		table string
+		count *int64
+	}{
+		{"purchases", &purge.Purchases},
+		{"checkout_attempts", &purge.CheckoutAttempts},
+		{"user_sale_limits", &purge.UserLimits},
+		{"items", &purge.Items},
+	}
+	for _, step := range steps {
+		res, err := tx.Exec(`DELETE FROM `+step.table+` WHERE sale_id = $1`, saleID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to delete %s: %w", step.table, err)
+		}
+		if *step.count, err = res.RowsAffected(); err != nil {
+			return nil, fmt.Errorf("failed to count deleted %s: %w", step.table, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return purge, nil
+}
+
+// PurgeRetiredSaleItems deletes the items and checkout attempts of up to
+// limit finished sales that ended before endedBefore and still have them,
+// one transaction per sale, oldest first. Purchases keep the item's name,
+// and the sale row, user limits and unsold items archive are kept, so user
+// history and reports survive. It returns the IDs of the purged sales and
+// the number of items deleted.
+func (s *DBStore) PurgeRetiredSaleItems(ctx context.Context, endedBefore time.Time, limit int) ([]int64, int64, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+        SELECT id FROM sales
+        WHERE NOT is_active AND NOT is_prepared AND end_time < $1 AND items_purged_at IS NULL
+        ORDER BY end_time
+        LIMIT $2`, endedBefore, limit)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to find retired sales: %w", err)
+	}
+	var saleIDs []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, 0, fmt.Errorf("failed to scan retired sale: %w", err)
+		}
+		saleIDs = append(saleIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to iterate retired sales: %w", err)
+	}
+
+	var purged []int64
+	var deleted int64
+	for _, saleID := range saleIDs {
+		var n int64
+		err := s.withTx(ctx, sql.LevelDefault, func(tx *sql.Tx) error {
+			if _, err := tx.Exec(`DELETE FROM checkout_attempts WHERE sale_id = $1`, saleID); err != nil {
+				return fmt.Errorf("failed to delete checkout attempts: %w", err)
+			}
+			res, err := tx.Exec(`DELETE FROM items WHERE sale_id = $1`, saleID)
+			if err != nil {
+				return fmt.Errorf("failed to delete items: %w", err)
+			}
+			if n, err = res.RowsAffected(); err != nil {
+				return fmt.Errorf("failed to count deleted items: %w", err)
+			}
+			if _, err := tx.Exec(`UPDATE sales SET items_purged_at = NOW() WHERE id = $1`, saleID); err != nil {
+				return fmt.Errorf("failed to mark sale items purged: %w", err)
+			}
+			return nil
+		})
+		if err != nil {
+			return purged, deleted, fmt.Errorf("sale ID %d: %w", saleID, err)
+		}
+		purged = append(purged, saleID)
+		deleted += n
+	}
+	return purged, deleted, nil
+}
+
+// DeleteSale removes a sale, whatever its state, along with every row that
+// refers to it through ON DELETE CASCADE. Unlike PurgeSale it leaves no
+// record of the sale at all.
+func (s *DBStore) DeleteSale(saleID int64) error {
+	if _, err := s.DB.Exec(`DELETE FROM sales WHERE id = $1`, saleID); err != nil {
+		return fmt.Errorf("failed to delete sale: %w", err)
 	}
 	return nil
 }
 
+// ListSaleReports returns a report row for each sale that started in
+// [from, to), oldest first. Sold counts come from the purchases themselves
+// rather than the sales counter.
+func (s *DBStore) ListSaleReports(from, to time.Time) ([]models.SaleReport, error) {
+	query := `
+        SELECT s.id, s.start_time, s.end_time, s.total_items,
+            COALESCE(p.sold, 0), COALESCE(p.buyers, 0), s.unsold_items
+        FROM sales s
+        LEFT JOIN (
+            SELECT sale_id, COUNT(*) AS sold, COUNT(DISTINCT user_id) AS buyers
+            FROM purchases
+            GROUP BY sale_id
+        ) p ON p.sale_id = s.id
+        WHERE s.start_time >= $1 AND s.start_time < $2 AND s.is_prepared = FALSE
+        ORDER BY s.start_time, s.id`
+
+	rows, err := s.DB.Query(query, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list sale reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []models.SaleReport{}
+	for rows.Next() {
+		var r models.SaleReport
+		var unsold sql.NullInt32
+		if err := rows.Scan(&r.SaleID, &r.StartTime, &r.EndTime, &r.TotalItems, &r.SoldItems, &r.UniqueBuyers, &unsold); err != nil {
+			return nil, fmt.Errorf("failed to scan sale report: %w", err)
+		}
+		if unsold.Valid {
+			count := int(unsold.Int32)
+			r.UnsoldItems = &count
+		}
+		r.DurationSeconds = int64(r.EndTime.Sub(r.StartTime) / time.Second)
+		if r.TotalItems > 0 {
+			r.SellThroughPercent = float64(r.SoldItems) * 100 / float64(r.TotalItems)
+		}
+		reports = append(reports, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to iterate sale reports: %w", err)
+	}
+	return reports, nil
+}
+
+// GetSaleStats reads a sale's inventory, buyer, and open-checkout counts in a
+// single statement so the figures come from one snapshot.
+func (s *DBStore) GetSaleStats(saleID int64) (*models.SaleStats, error) {
+	query := `
+        SELECT s.id, s.total_items, s.sold_items,
+            (SELECT COUNT(DISTINCT p.user_id) FROM purchases p WHERE p.sale_id = s.id),
+            (SELECT COUNT(*) FROM checkout_attempts ca
+             WHERE ca.sale_id = s.id AND ca.is_used = FALSE AND ca.expires_at > NOW())
+        FROM sales s
+        WHERE s.id = $1`
+
+	stats := &models.SaleStats{}
+	err := s.DB.QueryRow(query, saleID).Scan(
+		&stats.SaleID,
+		&stats.TotalItems,
+		&stats.SoldItems,
+		&stats.DistinctPurchasers,
+		&stats.ActiveCheckouts,
+	)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get sale stats: %w", err)
+	}
+	return stats, nil
+}
+
+// ReimageSaleItems rewrites image_url for every item of the sale from
+// template in one statement, substituting {sale_id}, {item_id} and {file}
+// (the last path segment of the current URL). It returns the number of
+// items updated.
+func (s *DBStore) ReimageSaleItems(saleID int64, template string) (int64, error) {
+	res, err := s.DB.Exec(`
+        UPDATE items
+        SET image_url = replace(replace(replace($2, '{sale_id}', sale_id::text), '{item_id}', id::text),
+                '{file}', regexp_replace(image_url, '^.*/', '')),
+            updated_at = NOW()
+        WHERE sale_id = $1`, saleID, template)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reimage sale items: %w", err)
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count reimaged items: %w", err)
+	}
+	return n, nil
+}
+
 func (s *DBStore) DeactivateSaleByID(saleID int64) error {
 	_, err := s.DB.Exec(`UPDATE sales SET is_active = FALSE WHERE id = $1`, saleID)
 	if err != nil {
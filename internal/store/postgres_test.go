@@ -0,0 +1,487 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"notcoin_contest/internal/models"
+	"notcoin_contest/migrations"
+)
+
+// testDatabaseURL names the Postgres database the store tests run against.
+// Tests needing it are skipped when it is unset.
+const testDatabaseURL = "TEST_DATABASE_URL"
+
+// testSchema connects to TEST_DATABASE_URL with a fresh, empty schema of its
+// own first on the search path, dropped again when the test ends.
+func testSchema(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv(testDatabaseURL)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDatabaseURL)
+	}
+
+	admin, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { admin.Close() })
+
+	schema := "test_" + randomHex(t, 6)
+	if _, err := admin.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("failed to create schema: %v", err)
+	}
+
+	// public stays on the path for extensions such as pg_trgm, which live
+	// there when the database already has them. pg_catalog is listed
+	// explicitly so the schema may shadow its functions.
+	db, err := sql.Open("postgres", withSearchPath(dsn, schema+",public,pg_catalog"))
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		if _, err := admin.Exec(`DROP SCHEMA ` + schema + ` CASCADE`); err != nil {
+			t.Errorf("failed to drop schema %s: %v", schema, err)
+		}
+	})
+	return db
+}
+
+// withSearchPath adds a search_path setting to dsn, which lib/pq sends as a
+// session setting, in either the URL or the key=value form.
+func withSearchPath(dsn, searchPath string) string {
+	if u, err := url.Parse(dsn); err == nil && u.Scheme != "" {
+		q := u.Query()
+		q.Set("search_path", searchPath)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return fmt.Sprintf("%s search_path='%s'", dsn, searchPath)
+}
+
+// testDB returns a store over a fresh schema with every migration applied.
+func testDB(t *testing.T) *DBStore {
+	t.Helper()
+	db := testSchema(t)
+	if err := RunMigrations(db, migrations.FS); err != nil {
+		t.Fatalf("failed to run migrations: %v", err)
+	}
+	return NewDBStore(db)
+}
+
+// seedSale creates an active sale, open for the next hour, with n unsold
+// items.
+func seedSale(t *testing.T, s *DBStore, n int) (*models.Sale, []models.Item) {
+	t.Helper()
+	now := time.Now()
+	sale, err := s.CreateSale(&models.Sale{
+		StartTime:  now.Add(-time.Minute),
+		EndTime:    now.Add(time.Hour),
+		TotalItems: n,
+		IsActive:   true,
+	})
+	if err != nil {
+		t.Fatalf("failed to create sale: %v", err)
+	}
+
+	items := make([]models.Item, n)
+	for i := range items {
+		items[i] = models.Item{SaleID: sale.ID, Name: fmt.Sprintf("Item #%d", i+1), Currency: "USD"}
+	}
+	items, err = s.CreateItemsBatch(items)
+	if err != nil {
+		t.Fatalf("failed to create items: %v", err)
+	}
+	return sale, items
+}
+
+// seedCheckout records an unused checkout attempt of userID for item.
+func seedCheckout(t *testing.T, s *DBStore, userID string, item models.Item) *models.CheckoutAttempt {
+	t.Helper()
+	attempt := &models.CheckoutAttempt{
+		ID:        randomHex(t, 8),
+		UserID:    userID,
+		ItemID:    item.ID,
+		SaleID:    item.SaleID,
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+	if err := s.CreateCheckoutAttempt(attempt); err != nil {
+		t.Fatalf("failed to create checkout attempt: %v", err)
+	}
+	return attempt
+}
+
+func purchaseParams(attempt *models.CheckoutAttempt) PurchaseParams {
+	return PurchaseParams{
+		UserID:           attempt.UserID,
+		ItemID:           attempt.ItemID,
+		SaleID:           attempt.SaleID,
+		CheckoutCode:     attempt.ID,
+		UserLimitPerSale: 10,
+		Isolation:        sql.LevelReadCommitted,
+	}
+}
+
+// purchaseConcurrently runs the purchases at once and returns their errors
+// in the same order.
+func purchaseConcurrently(s *DBStore, ps ...PurchaseParams) []error {
+	errs := make([]error, len(ps))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, p := range ps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, _, errs[i] = s.ExecutePurchaseTransaction(p)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return errs
+}
+
+func countRows(t *testing.T, s *DBStore, query string, args ...any) int {
+	t.Helper()
+	var n int
+	if err := s.DB.QueryRow(query, args...).Scan(&n); err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	return n
+}
+
+func randomHex(t *testing.T, n int) string {
+	t.Helper()
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		t.Fatalf("failed to generate random bytes: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+func TestExecutePurchaseTransactionSellsItemOnce(t *testing.T) {
+	s := testDB(t)
+	_, items := seedSale(t, s, 1)
+	first := seedCheckout(t, s, "user-1", items[0])
+	second := seedCheckout(t, s, "user-2", items[0])
+
+	errs := purchaseConcurrently(s, purchaseParams(first), purchaseParams(second))
+
+	var sold, refused int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case errors.Is(err, ErrDBItemAlreadySold):
+			refused++
+		default:
+			t.Fatalf("unexpected purchase error: %v", err)
+		}
+	}
+	if sold != 1 || refused != 1 {
+		t.Fatalf("got %d purchases and %d refusals, want 1 and 1", sold, refused)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM purchases WHERE item_id = $1`, items[0].ID); n != 1 {
+		t.Fatalf("item has %d purchase rows, want 1", n)
+	}
+}
+
+func TestExecutePurchaseTransactionTranslatesItemIndexViolation(t *testing.T) {
+	s := testDB(t)
+	_, items := seedSale(t, s, 1)
+	attempt := seedCheckout(t, s, "user-1", items[0])
+
+	// A purchase row the item lock knows nothing about, as a regression in
+	// the locking logic would leave behind; only the index stops a second.
+	_, err := s.DB.Exec(`
+        INSERT INTO purchases (user_id, item_id, sale_id, checkout_code, purchased_at)
+        VALUES ('user-0', $1, $2, 'stray', NOW())`, items[0].ID, items[0].SaleID)
+	if err != nil {
+		t.Fatalf("failed to insert stray purchase: %v", err)
+	}
+
+	_, _, err = s.ExecutePurchaseTransaction(purchaseParams(attempt))
+	if !errors.Is(err, ErrDBItemAlreadySold) {
+		t.Fatalf("err = %v, want %v", err, ErrDBItemAlreadySold)
+	}
+}
+
+func TestExecutePurchaseTransactionUsesCodeOnce(t *testing.T) {
+	s := testDB(t)
+	_, items := seedSale(t, s, 1)
+	attempt := seedCheckout(t, s, "user-1", items[0])
+
+	const tries = 5
+	ps := make([]PurchaseParams, tries)
+	for i := range ps {
+		ps[i] = purchaseParams(attempt)
+	}
+	errs := purchaseConcurrently(s, ps...)
+
+	var sold int
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			sold++
+		case errors.Is(err, ErrDBCheckoutCodeAlreadyUsed):
+		default:
+			t.Fatalf("unexpected purchase error: %v", err)
+		}
+	}
+	if sold != 1 {
+		t.Fatalf("code bought %d items, want 1", sold)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM purchases WHERE checkout_code = $1`, attempt.ID); n != 1 {
+		t.Fatalf("code has %d purchase rows, want 1", n)
+	}
+}
+
+// setDBClock makes NOW() in the test schema return the time selected by
+// query, so the database clock can disagree with the app's.
+func setDBClock(t *testing.T, s *DBStore, query string, args ...any) {
+	t.Helper()
+	if _, err := s.DB.Exec(`CREATE TABLE IF NOT EXISTS test_clock (at TIMESTAMPTZ NOT NULL)`); err != nil {
+		t.Fatalf("failed to create test clock: %v", err)
+	}
+	if _, err := s.DB.Exec(`DELETE FROM test_clock`); err != nil {
+		t.Fatalf("failed to reset test clock: %v", err)
+	}
+	if _, err := s.DB.Exec(`INSERT INTO test_clock (at) `+query, args...); err != nil {
+		t.Fatalf("failed to set test clock: %v", err)
+	}
+	_, err := s.DB.Exec(`CREATE OR REPLACE FUNCTION now() RETURNS TIMESTAMPTZ LANGUAGE sql STABLE AS 'SELECT at FROM test_clock'`)
+	if err != nil {
+		t.Fatalf("failed to override NOW(): %v", err)
+	}
+}
+
+func TestExecutePurchaseTransactionUsesDatabaseClock(t *testing.T) {
+	tests := []struct {
+		name string
+		// appEnd is the sale's end time relative to the app clock.
+		appEnd time.Duration
+		// clock selects the database time, given the sale's ID.
+		clock   string
+		wantErr error
+	}{
+		{
+			name:    "app clock ahead of database: ended for the app, open in the database",
+			appEnd:  -time.Minute,
+			clock:   `SELECT end_time - INTERVAL '1 minute' FROM sales WHERE id = $1`,
+			wantErr: nil,
+		},
+		{
+			name:    "app clock behind database: open for the app, ended in the database",
+			appEnd:  time.Hour,
+			clock:   `SELECT end_time + INTERVAL '1 minute' FROM sales WHERE id = $1`,
+			wantErr: ErrDBSaleNotActive,
+		},
+		{
+			name:    "database clock before the sale starts",
+			appEnd:  time.Hour,
+			clock:   `SELECT start_time - INTERVAL '1 minute' FROM sales WHERE id = $1`,
+			wantErr: ErrDBSaleNotStarted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := testDB(t)
+			sale, items := seedSale(t, s, 1)
+			end := time.Now().Add(tt.appEnd)
+			_, err := s.DB.Exec(`UPDATE sales SET start_time = $2, end_time = $3 WHERE id = $1`,
+				sale.ID, end.Add(-2*time.Hour), end)
+			if err != nil {
+				t.Fatalf("failed to move sale: %v", err)
+			}
+			attempt := seedCheckout(t, s, "user-1", items[0])
+			// Keep the code valid past every clock tried, so only the sale's
+			// times decide.
+			if _, err := s.DB.Exec(`UPDATE checkout_attempts SET expires_at = $2 WHERE id = $1`, attempt.ID, end.Add(time.Hour)); err != nil {
+				t.Fatalf("failed to extend checkout attempt: %v", err)
+			}
+			setDBClock(t, s, tt.clock, sale.ID)
+
+			_, _, err = s.ExecutePurchaseTransaction(purchaseParams(attempt))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestExecutePurchaseTransactionRejectsUnknownCode(t *testing.T) {
+	s := testDB(t)
+	_, items := seedSale(t, s, 1)
+
+	// A code that reached Redis but was never written to the database.
+	attempt := &models.CheckoutAttempt{ID: randomHex(t, 8), UserID: "user-1", ItemID: items[0].ID, SaleID: items[0].SaleID}
+
+	_, _, err := s.ExecutePurchaseTransaction(purchaseParams(attempt))
+	if !errors.Is(err, ErrDBCheckoutAttemptNotFound) {
+		t.Fatalf("err = %v, want %v", err, ErrDBCheckoutAttemptNotFound)
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM items WHERE id = $1 AND is_sold`, items[0].ID); n != 0 {
+		t.Fatal("item was sold to a code the database does not know")
+	}
+}
+
+func TestRunMigrationsRollsBackFailedMigration(t *testing.T) {
+	db := testSchema(t)
+	fsys := fstest.MapFS{
+		"0001_good.sql": {Data: []byte(`CREATE TABLE good (id INT);`)},
+		"0002_broken.sql": {Data: []byte(`
+            CREATE TABLE half_built (id INT);
+            INSERT INTO good (id) VALUES (1);
+            SELECT no_such_column FROM half_built;`)},
+		"0003_after.sql": {Data: []byte(`CREATE TABLE after_broken (id INT);`)},
+	}
+
+	err := RunMigrations(db, fsys)
+	if err == nil {
+		t.Fatal("RunMigrations succeeded with a broken migration")
+	}
+	if !strings.Contains(err.Error(), "0002_broken.sql") {
+		t.Fatalf("error %q does not name the broken migration", err)
+	}
+
+	s := NewDBStore(db)
+	tableExists := func(name string) bool {
+		return countRows(t, s, `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1`, name) > 0
+	}
+	if !tableExists("good") {
+		t.Fatal("migration before the broken one was not applied")
+	}
+	if tableExists("half_built") {
+		t.Fatal("broken migration left its table behind")
+	}
+	if tableExists("after_broken") {
+		t.Fatal("migration after the broken one was applied")
+	}
+	if n := countRows(t, s, `SELECT COUNT(*) FROM good`); n != 0 {
+		t.Fatalf("broken migration left %d rows behind", n)
+	}
+
+	var applied []string
+	rows, err := db.Query(`SELECT version FROM schema_migrations ORDER BY version`)
+	if err != nil {
+		t.Fatalf("failed to read applied migrations: %v", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			t.Fatalf("failed to scan applied migration: %v", err)
+		}
+		applied = append(applied, version)
+	}
+	if len(applied) != 1 || applied[0] != "0001_good.sql" {
+		t.Fatalf("applied migrations = %v, want [0001_good.sql]", applied)
+	}
+}
+
+func TestCountExpiredCheckoutAttempts(t *testing.T) {
+	s := testDB(t)
+	sale, items := seedSale(t, s, 3)
+	lapsed := seedCheckout(t, s, "user-1", items[0])
+	bought := seedCheckout(t, s, "user-2", items[1])
+	seedCheckout(t, s, "user-3", items[2])
+
+	if _, err := s.DB.Exec(`UPDATE checkout_attempts SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, lapsed.ID); err != nil {
+		t.Fatalf("failed to expire checkout attempt: %v", err)
+	}
+	if _, _, err := s.ExecutePurchaseTransaction(purchaseParams(bought)); err != nil {
+		t.Fatalf("purchase failed: %v", err)
+	}
+	if n, err := s.CountExpiredCheckoutAttempts(sale.ID); err != nil || n != 1 {
+		t.Fatalf("CountExpiredCheckoutAttempts = %d, %v; want 1", n, err)
+	}
+
+	if _, err := s.DB.Exec(`UPDATE sales SET is_active = FALSE WHERE id = $1`, sale.ID); err != nil {
+		t.Fatalf("failed to deactivate sale: %v", err)
+	}
+	if _, err := s.MarkOrphanedAttempts(sale.ID); err != nil {
+		t.Fatalf("MarkOrphanedAttempts failed: %v", err)
+	}
+	if n, err := s.CountExpiredCheckoutAttempts(sale.ID); err != nil || n != 2 {
+		t.Fatalf("CountExpiredCheckoutAttempts after close = %d, %v; want 2", n, err)
+	}
+}
+
+func TestItemHasOpenCheckout(t *testing.T) {
+	s := testDB(t)
+	_, items := seedSale(t, s, 1)
+
+	if open, err := s.ItemHasOpenCheckout(items[0].ID); err != nil || open {
+		t.Fatalf("ItemHasOpenCheckout before checkout = %v, %v; want false", open, err)
+	}
+	attempt := seedCheckout(t, s, "user-1", items[0])
+	if open, err := s.ItemHasOpenCheckout(items[0].ID); err != nil || !open {
+		t.Fatalf("ItemHasOpenCheckout with open code = %v, %v; want true", open, err)
+	}
+	if _, err := s.DB.Exec(`UPDATE checkout_attempts SET expires_at = NOW() - INTERVAL '1 minute' WHERE id = $1`, attempt.ID); err != nil {
+		t.Fatalf("failed to expire checkout attempt: %v", err)
+	}
+	if open, err := s.ItemHasOpenCheckout(items[0].ID); err != nil || open {
+		t.Fatalf("ItemHasOpenCheckout after expiry = %v, %v; want false", open, err)
+	}
+}
+
+func TestActivatePreparedSaleHonorsSaleDuration(t *testing.T) {
+	s := testDB(t)
+	now := time.Now()
+	durationSeconds := 600
+	prepared, err := s.CreatePreparedSale(&models.Sale{
+		StartTime:       now,
+		EndTime:         now.Add(time.Hour),
+		TotalItems:      1,
+		DurationSeconds: &durationSeconds,
+	})
+	if err != nil {
+		t.Fatalf("failed to create prepared sale: %v", err)
+	}
+	if _, err := s.CreateItemsBatch([]models.Item{{SaleID: prepared.ID, Name: "Item #1", Currency: "USD"}}); err != nil {
+		t.Fatalf("failed to create items: %v", err)
+	}
+
+	sale, _, err := s.ActivatePreparedSale(nil, now, now.Add(time.Hour))
+	if err != nil {
+		t.Fatalf("ActivatePreparedSale failed: %v", err)
+	}
+	if sale == nil || sale.ID != prepared.ID {
+		t.Fatalf("activated sale = %+v, want sale %d", sale, prepared.ID)
+	}
+	if got := sale.EndTime.Sub(sale.StartTime); got != 10*time.Minute {
+		t.Fatalf("sale runs for %s, want 10m", got)
+	}
+}
+
+func TestRunMigrationsIgnoresStatementTimeout(t *testing.T) {
+	db := testSchema(t)
+	// One connection, so the session timeout set here is the one the
+	// migration runs under.
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(`SET statement_timeout = 50`); err != nil {
+		t.Fatalf("failed to set statement timeout: %v", err)
+	}
+	fsys := fstest.MapFS{
+		"0001_slow.sql": {Data: []byte(`SELECT pg_sleep(0.2);`)},
+	}
+
+	if err := RunMigrations(db, fsys); err != nil {
+		t.Fatalf("slow migration failed: %v", err)
+	}
+	if _, err := db.Exec(`SELECT pg_sleep(0.2)`); err == nil {
+		t.Fatal("statement timeout no longer applies after the migration")
+	}
+}
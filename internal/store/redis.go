@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
 	"time"
 
 	"notcoin_contest/internal/models"
@@ -13,15 +14,70 @@ import (
 )
 
 type RedisStore struct {
-	Client *redis.Client
+	Client    redis.UniversalClient
+	keyPrefix string
+	opTimeout time.Duration
 }
 
-func NewRedisClient(addr, password string, db int) (*redis.Client, error) {
-	client := redis.NewClient(&redis.Options{
-		Addr:     addr,
-		Password: password,
-		DB:       db,
-	})
+// Redis deployment modes accepted by NewRedisClient.
+const (
+	RedisModeStandalone = "standalone"
+	RedisModeSentinel   = "sentinel"
+	RedisModeCluster    = "cluster"
+)
+
+// RedisClientOptions selects the deployment and tunes how the client retries
+// and times out so a Redis restart during a sale degrades into retries
+// rather than hard failures.
+type RedisClientOptions struct {
+	// Mode is RedisModeStandalone (the default), RedisModeSentinel or
+	// RedisModeCluster.
+	Mode string
+	// Addrs are the sentinel or cluster seed addresses; standalone uses addr.
+	Addrs []string
+	// MasterName is the sentinel master set name.
+	MasterName string
+
+	MaxRetries  int
+	DialTimeout time.Duration
+	ReadTimeout time.Duration
+}
+
+// NewRedisClient connects to a standalone Redis at addr, a Sentinel-managed
+// master, or a Cluster, depending on opts.Mode, and pings it.
+func NewRedisClient(addr, password string, db int, opts RedisClientOptions) (redis.UniversalClient, error) {
+	var client redis.UniversalClient
+	switch opts.Mode {
+	case "", RedisModeStandalone:
+		client = redis.NewClient(&redis.Options{
+			Addr:        addr,
+			Password:    password,
+			DB:          db,
+			MaxRetries:  opts.MaxRetries,
+			DialTimeout: opts.DialTimeout,
+			ReadTimeout: opts.ReadTimeout,
+		})
+	case RedisModeSentinel:
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:    opts.MasterName,
+			SentinelAddrs: opts.Addrs,
+			Password:      password,
+			DB:            db,
+			MaxRetries:    opts.MaxRetries,
+			DialTimeout:   opts.DialTimeout,
+			ReadTimeout:   opts.ReadTimeout,
+		})
+	case RedisModeCluster:
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:       opts.Addrs,
+			Password:    password,
+			MaxRetries:  opts.MaxRetries,
+			DialTimeout: opts.DialTimeout,
+			ReadTimeout: opts.ReadTimeout,
+		})
+	default:
+		return nil, fmt.Errorf("unknown redis mode %q", opts.Mode)
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -34,8 +90,24 @@ func NewRedisClient(addr, password string, db int) (*redis.Client, error) {
 	return client, nil
 }
 
-func NewRedisStore(client *redis.Client) *RedisStore {
-	return &RedisStore{Client: client}
+// NewRedisStore builds a store whose keys are all namespaced with keyPrefix,
+// so that several environments can share one Redis instance. Each operation
+// gives up after opTimeout, or only at the caller's deadline if it is zero.
+func NewRedisStore(client redis.UniversalClient, keyPrefix string, opTimeout time.Duration) *RedisStore {
+	return &RedisStore{Client: client, keyPrefix: keyPrefix, opTimeout: opTimeout}
+}
+
+// withTimeout derives the context for one operation, so that a slow Redis
+// fails the operation quickly and callers can fall back to the database.
+func (s *RedisStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.opTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, s.opTimeout)
+}
+
+func (s *RedisStore) key(format string, args ...any) string {
+	return s.keyPrefix + fmt.Sprintf(format, args...)
 }
 
 func (s *RedisStore) Close() error {
@@ -45,8 +117,32 @@ func (s *RedisStore) Close() error {
 	return nil
 }
 
+// PoolStats reports the state of the client's connection pool, summed over
+// all nodes in cluster mode.
+func (s *RedisStore) PoolStats() models.RedisPoolStats {
+	st := s.Client.PoolStats()
+	return models.RedisPoolStats{
+		Hits:       st.Hits,
+		Misses:     st.Misses,
+		Timeouts:   st.Timeouts,
+		TotalConns: st.TotalConns,
+		IdleConns:  st.IdleConns,
+		StaleConns: st.StaleConns,
+	}
+}
+
+func (s *RedisStore) Ping(ctx context.Context) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return s.Client.Ping(ctx).Err()
+}
+
 func (s *RedisStore) StoreCheckoutCode(ctx context.Context, attempt *models.CheckoutAttempt, ttl time.Duration) error {
-	key := fmt.Sprintf("checkout_code:%s", attempt.ID)
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("checkout_code:%s", attempt.ID)
 
 	attemptJSON, err := json.Marshal(attempt)
 	if err != nil {
@@ -61,7 +157,10 @@ func (s *RedisStore) StoreCheckoutCode(ctx context.Context, attempt *models.Chec
 }
 
 func (s *RedisStore) GetCheckoutAttempt(ctx context.Context, code string) (*models.CheckoutAttempt, error) {
-	key := fmt.Sprintf("checkout_code:%s", code)
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("checkout_code:%s", code)
 	val, err := s.Client.Get(ctx, key).Result()
 	if err != nil {
 		if err == redis.Nil {
@@ -77,8 +176,33 @@ func (s *RedisStore) GetCheckoutAttempt(ctx context.Context, code string) (*mode
 	return &attempt, nil
 }
 
+// MarkCheckoutCodeUsed overwrites the cached attempt with a used copy,
+// keeping its expiry, so readers that still find the key reject the code.
+// A key that has already expired or been deleted is left absent.
+func (s *RedisStore) MarkCheckoutCodeUsed(ctx context.Context, attempt *models.CheckoutAttempt) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	used := *attempt
+	used.IsUsed = true
+	attemptJSON, err := json.Marshal(&used)
+	if err != nil {
+		return fmt.Errorf("failed to marshal checkout attempt: %w", err)
+	}
+
+	key := s.key("checkout_code:%s", attempt.ID)
+	err = s.Client.SetArgs(ctx, key, attemptJSON, redis.SetArgs{Mode: "XX", KeepTTL: true}).Err()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("failed to mark checkout code as used in redis: %w", err)
+	}
+	return nil
+}
+
 func (s *RedisStore) DeleteCheckoutCode(ctx context.Context, code string) error {
-	key := fmt.Sprintf("checkout_code:%s", code)
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("checkout_code:%s", code)
 	err := s.Client.Del(ctx, key).Err()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -88,3 +212,515 @@ func (s *RedisStore) DeleteCheckoutCode(ctx context.Context, code string) error
 	}
 	return nil
 }
+
+// MarkSaleSoldOut flags the sale as sold out until the flag expires with the
+// sale, letting checkouts fail fast without touching the database.
+func (s *RedisStore) MarkSaleSoldOut(ctx context.Context, saleID int64, ttl time.Duration) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("sale:%d:soldout", saleID)
+	if err := s.Client.Set(ctx, key, 1, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set sold-out flag in redis: %w", err)
+	}
+	return nil
+}
+
+// MarkSellThroughWarned records that the sell-through warning fired for the
+// sale and reports whether this call was the first to do so.
+func (s *RedisStore) MarkSellThroughWarned(ctx context.Context, saleID int64, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	first, err := s.Client.SetNX(ctx, s.key("sale:%d:sell_through_warned", saleID), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to set sell-through warning flag in redis: %w", err)
+	}
+	return first, nil
+}
+
+// ClearSaleSoldOut removes a sold-out flag that turned out to be wrong.
+func (s *RedisStore) ClearSaleSoldOut(ctx context.Context, saleID int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.Del(ctx, s.key("sale:%d:soldout", saleID)).Err(); err != nil {
+		return fmt.Errorf("failed to clear sold-out flag in redis: %w", err)
+	}
+	return nil
+}
+
+// ClearSaleFlags removes the sale's sold-out and sell-through flags, its
+// mystery pool and its checkout queue. Each key is deleted on its own so the
+// call also works on a cluster, where the keys may live on different nodes.
+func (s *RedisStore) ClearSaleFlags(ctx context.Context, saleID int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pipe := s.Client.Pipeline()
+	pipe.Del(ctx, s.key("sale:%d:soldout", saleID))
+	pipe.Del(ctx, s.key("sale:%d:sell_through_warned", saleID))
+	pool, marker := s.mysteryPoolKeys(saleID)
+	pipe.Del(ctx, pool)
+	pipe.Del(ctx, marker)
+	for _, key := range s.checkoutQueueKeys(saleID) {
+		pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to clear sale flags in redis: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) IsSaleSoldOut(ctx context.Context, saleID int64) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("sale:%d:soldout", saleID)
+	n, err := s.Client.Exists(ctx, key).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check sold-out flag in redis: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SetMaintenance turns maintenance mode on with the given message, or off.
+// The flag has no TTL so it survives restarts and is shared by all replicas.
+func (s *RedisStore) SetMaintenance(ctx context.Context, enabled bool, message string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("maintenance")
+	var err error
+	if enabled {
+		err = s.Client.Set(ctx, key, message, 0).Err()
+	} else {
+		err = s.Client.Del(ctx, key).Err()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update maintenance flag in redis: %w", err)
+	}
+	return nil
+}
+
+// GetMaintenance reports whether maintenance mode is on and its message.
+func (s *RedisStore) GetMaintenance(ctx context.Context) (bool, string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	message, err := s.Client.Get(ctx, s.key("maintenance")).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return false, "", nil
+		}
+		return false, "", fmt.Errorf("failed to get maintenance flag from redis: %w", err)
+	}
+	return true, message, nil
+}
+
+// IncrFunnelCounter bumps one funnel counter of a sale. The hash expires
+// ttl after its last update.
+func (s *RedisStore) IncrFunnelCounter(ctx context.Context, saleID int64, field string, ttl time.Duration) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.key("funnel:sale:%d", saleID)
+	pipe := s.Client.TxPipeline()
+	pipe.HIncrBy(ctx, key, field, 1)
+	pipe.Expire(ctx, key, ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to increment funnel counter in redis: %w", err)
+	}
+	return nil
+}
+
+func (s *RedisStore) GetFunnelCounters(ctx context.Context, saleID int64) (map[string]int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	values, err := s.Client.HGetAll(ctx, s.key("funnel:sale:%d", saleID)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get funnel counters from redis: %w", err)
+	}
+	counters := make(map[string]int64, len(values))
+	for field, value := range values {
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid funnel counter %s=%q: %w", field, value, err)
+		}
+		counters[field] = n
+	}
+	return counters, nil
+}
+
+// mysteryPoolKeys returns the keys of the sale's mystery pool and of its
+// seeded marker. They share a hash tag so both can be written in one MULTI on
+// a cluster.
+func (s *RedisStore) mysteryPoolKeys(saleID int64) (pool, marker string) {
+	return s.key("sale:{%d}:available", saleID), s.key("sale:{%d}:available:seeded", saleID)
+}
+
+// SeedAvailableItems loads the sale's mystery pool with itemIDs unless it has
+// been seeded before, and reports whether this call seeded it. The items and
+// the seeded marker are written in one MULTI, so the marker is never set
+// without the items. The marker is watched, and a concurrent caller that
+// seeds first makes this call back off.
+func (s *RedisStore) SeedAvailableItems(ctx context.Context, saleID int64, itemIDs []int64, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pool, marker := s.mysteryPoolKeys(saleID)
+	seeded := false
+	err := s.Client.Watch(ctx, func(tx *redis.Tx) error {
+		n, err := tx.Exists(ctx, marker).Result()
+		if err != nil || n > 0 {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			const chunkSize = 1000
+			for start := 0; start < len(itemIDs); start += chunkSize {
+				end := min(start+chunkSize, len(itemIDs))
+				members := make([]any, 0, end-start)
+				for _, id := range itemIDs[start:end] {
+					members = append(members, id)
+				}
+				pipe.SAdd(ctx, pool, members...)
+			}
+			pipe.Expire(ctx, pool, ttl)
+			pipe.Set(ctx, marker, 1, ttl)
+			return nil
+		})
+		seeded = err == nil
+		return err
+	}, marker)
+	if errors.Is(err, redis.TxFailedErr) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to seed mystery pool in redis: %w", err)
+	}
+	return seeded, nil
+}
+
+// IsAvailableItemsSeeded reports whether the sale's mystery pool was seeded.
+func (s *RedisStore) IsAvailableItemsSeeded(ctx context.Context, saleID int64) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, marker := s.mysteryPoolKeys(saleID)
+	n, err := s.Client.Exists(ctx, marker).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to check mystery pool in redis: %w", err)
+	}
+	return n > 0, nil
+}
+
+// PopAvailableItem removes and returns a random item from the sale's mystery
+// pool. SPOP is atomic, so no item is handed out twice. ok is false when the
+// pool is empty.
+func (s *RedisStore) PopAvailableItem(ctx context.Context, saleID int64) (itemID int64, ok bool, err error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pool, _ := s.mysteryPoolKeys(saleID)
+	itemID, err = s.Client.SPop(ctx, pool).Int64()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, false, nil
+		}
+		return 0, false, fmt.Errorf("failed to pop mystery item from redis: %w", err)
+	}
+	return itemID, true, nil
+}
+
+// ReturnAvailableItem puts an item that was popped but never reserved back
+// into the mystery pool.
+func (s *RedisStore) ReturnAvailableItem(ctx context.Context, saleID, itemID int64) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pool, _ := s.mysteryPoolKeys(saleID)
+	if err := s.Client.SAdd(ctx, pool, itemID).Err(); err != nil {
+		return fmt.Errorf("failed to return mystery item to redis: %w", err)
+	}
+	return nil
+}
+
+// ReturnAvailableItems puts items back into the sale's mystery pool and
+// keeps the pool expiring after ttl, since SPOP deletes a pool it empties.
+func (s *RedisStore) ReturnAvailableItems(ctx context.Context, saleID int64, itemIDs []int64, ttl time.Duration) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pool, _ := s.mysteryPoolKeys(saleID)
+	members := make([]any, len(itemIDs))
+	for i, id := range itemIDs {
+		members[i] = id
+	}
+	_, err := s.Client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, pool, members...)
+		pipe.Expire(ctx, pool, ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to return mystery items to redis: %w", err)
+	}
+	return nil
+}
+
+// checkoutQueueKeys returns the sale's checkout slot, queue and last-seen
+// keys. They share a hash tag so the admission script can touch all three on
+// a cluster.
+func (s *RedisStore) checkoutQueueKeys(saleID int64) []string {
+	return []string{
+		s.key("sale:{%d}:checkout_slots", saleID),
+		s.key("sale:{%d}:queue", saleID),
+		s.key("sale:{%d}:queue:seen", saleID),
+	}
+}
+
+// admitCheckoutScript frees slots whose hold has run out, drops queued users
+// who stopped polling, and then either gives the user a slot, returning 0, or
+// keeps them queued, returning their 1-based position. Users are admitted in
+// the order they first joined, so a later arrival cannot jump ahead while
+// earlier ones are still polling.
+var admitCheckoutScript = redis.NewScript(`
+local now, capacity, user = ARGV[1], tonumber(ARGV[2]), ARGV[3]
+local holdUntil, staleBefore, ttl = ARGV[4], ARGV[5], ARGV[6]
+local slot = "user:" .. user
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+for _, stale in ipairs(redis.call("ZRANGEBYSCORE", KEYS[3], "-inf", staleBefore)) do
+    redis.call("ZREM", KEYS[2], stale)
+end
+redis.call("ZREMRANGEBYSCORE", KEYS[3], "-inf", staleBefore)
+
+local position = 0
+if redis.call("ZSCORE", KEYS[1], slot) then
+    redis.call("ZADD", KEYS[1], holdUntil, slot)
+else
+    redis.call("ZADD", KEYS[2], "NX", now, user)
+    redis.call("ZADD", KEYS[3], now, user)
+    local free = math.max(capacity - redis.call("ZCARD", KEYS[1]), 0)
+    local rank = redis.call("ZRANK", KEYS[2], user)
+    if rank < free then
+        redis.call("ZREM", KEYS[2], user)
+        redis.call("ZREM", KEYS[3], user)
+        redis.call("ZADD", KEYS[1], holdUntil, slot)
+    else
+        position = rank - free + 1
+    end
+end
+for _, key in ipairs(KEYS) do
+    redis.call("PEXPIRE", key, ttl)
+end
+return position`)
+
+// AdmitCheckout lets the user into the sale's checkout if fewer than
+// capacity slots are held, holding a slot for them until hold passes, and
+// returns 0. Otherwise the user joins or stays in the sale's queue and their
+// position is returned. Queued users who have not asked again within
+// staleAfter lose their place.
+func (s *RedisStore) AdmitCheckout(ctx context.Context, saleID int64, userID string, capacity int, hold, staleAfter, ttl time.Duration) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	now := time.Now()
+	position, err := admitCheckoutScript.Run(ctx, s.Client, s.checkoutQueueKeys(saleID),
+		now.UnixMilli(), capacity, userID,
+		now.Add(hold).UnixMilli(), now.Add(-staleAfter).UnixMilli(), ttl.Milliseconds(),
+	).Int64()
+	if err != nil {
+		return 0, fmt.Errorf("failed to admit checkout in redis: %w", err)
+	}
+	return position, nil
+}
+
+// OccupyCheckoutSlot turns the slot held for the attempt's user into one held
+// by its code until the code expires.
+func (s *RedisStore) OccupyCheckoutSlot(ctx context.Context, attempt *models.CheckoutAttempt) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	key := s.checkoutQueueKeys(attempt.SaleID)[0]
+	pipe := s.Client.TxPipeline()
+	pipe.ZRem(ctx, key, "user:"+attempt.UserID)
+	pipe.ZAdd(ctx, key, redis.Z{Score: float64(attempt.ExpiresAt.UnixMilli()), Member: "code:" + attempt.ID})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to occupy checkout slot in redis: %w", err)
+	}
+	return nil
+}
+
+// ReleaseUserCheckoutSlot frees the slot held for a user whose checkout
+// failed after being admitted.
+func (s *RedisStore) ReleaseUserCheckoutSlot(ctx context.Context, saleID int64, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.ZRem(ctx, s.checkoutQueueKeys(saleID)[0], "user:"+userID).Err(); err != nil {
+		return fmt.Errorf("failed to release checkout slot in redis: %w", err)
+	}
+	return nil
+}
+
+// ReleaseCodeCheckoutSlot frees the slot held by a checkout code once it has
+// been used.
+func (s *RedisStore) ReleaseCodeCheckoutSlot(ctx context.Context, saleID int64, code string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.ZRem(ctx, s.checkoutQueueKeys(saleID)[0], "code:"+code).Err(); err != nil {
+		return fmt.Errorf("failed to release checkout slot in redis: %w", err)
+	}
+	return nil
+}
+
+// releaseLockScript deletes a lock only if it is still held by the caller, so
+// a lock that expired and was taken by another instance is left alone.
+var releaseLockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+    return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// AcquireLock takes the named lock for owner until ttl passes and reports
+// whether it was acquired. Instances use it to elect one of them to run a
+// background job.
+func (s *RedisStore) AcquireLock(ctx context.Context, name, owner string, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	acquired, err := s.Client.SetNX(ctx, s.key("lock:%s", name), owner, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to acquire lock %q in redis: %w", name, err)
+	}
+	return acquired, nil
+}
+
+// ReleaseLock releases the named lock if owner still holds it.
+func (s *RedisStore) ReleaseLock(ctx context.Context, name, owner string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := releaseLockScript.Run(ctx, s.Client, []string{s.key("lock:%s", name)}, owner).Err(); err != nil {
+		return fmt.Errorf("failed to release lock %q in redis: %w", name, err)
+	}
+	return nil
+}
+
+// AddToUserList adds userID to the named user list set.
+func (s *RedisStore) AddToUserList(ctx context.Context, list, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.SAdd(ctx, s.key("users:%s", list), userID).Err(); err != nil {
+		return fmt.Errorf("failed to add user to %s in redis: %w", list, err)
+	}
+	return nil
+}
+
+// RemoveFromUserList removes userID from the named user list set.
+func (s *RedisStore) RemoveFromUserList(ctx context.Context, list, userID string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.SRem(ctx, s.key("users:%s", list), userID).Err(); err != nil {
+		return fmt.Errorf("failed to remove user from %s in redis: %w", list, err)
+	}
+	return nil
+}
+
+// GetUserList returns the members of the named user list set.
+func (s *RedisStore) GetUserList(ctx context.Context, list string) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	members, err := s.Client.SMembers(ctx, s.key("users:%s", list)).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from redis: %w", list, err)
+	}
+	return members, nil
+}
+
+// UserListAccess reports, in one round trip, whether userID is denylisted,
+// whether an allowlist is in force, and whether userID is on it.
+func (s *RedisStore) UserListAccess(ctx context.Context, userID string) (denied, allowlistActive, allowed bool, err error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	pipe := s.Client.Pipeline()
+	deniedCmd := pipe.SIsMember(ctx, s.key("users:denylist"), userID)
+	sizeCmd := pipe.SCard(ctx, s.key("users:allowlist"))
+	allowedCmd := pipe.SIsMember(ctx, s.key("users:allowlist"), userID)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, false, false, fmt.Errorf("failed to check user lists in redis: %w", err)
+	}
+	return deniedCmd.Val(), sizeCmd.Val() > 0, allowedCmd.Val(), nil
+}
+
+// GetCache returns the cached value stored under name, or nil on a miss.
+func (s *RedisStore) GetCache(ctx context.Context, name string) ([]byte, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	data, err := s.Client.Get(ctx, s.key("cache:%s", name)).Bytes()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get %s from redis cache: %w", name, err)
+	}
+	return data, nil
+}
+
+// SetCache caches data under name for ttl.
+func (s *RedisStore) SetCache(ctx context.Context, name string, data []byte, ttl time.Duration) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.Set(ctx, s.key("cache:%s", name), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set %s in redis cache: %w", name, err)
+	}
+	return nil
+}
+
+// DeleteCache removes the value cached under name.
+func (s *RedisStore) DeleteCache(ctx context.Context, name string) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if err := s.Client.Del(ctx, s.key("cache:%s", name)).Err(); err != nil {
+		return fmt.Errorf("failed to delete %s from redis cache: %w", name, err)
+	}
+	return nil
+}
+
+// StoreCheckoutAlias maps a short alias to a full checkout code for ttl
+// unless the alias is taken, and reports whether it was stored.
+func (s *RedisStore) StoreCheckoutAlias(ctx context.Context, alias, code string, ttl time.Duration) (bool, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	stored, err := s.Client.SetNX(ctx, s.key("checkout_alias:%s", alias), code, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed to store checkout alias in redis: %w", err)
+	}
+	return stored, nil
+}
+
+// GetCheckoutAlias returns the full checkout code behind alias, or "" if the
+// alias is unknown or expired.
+func (s *RedisStore) GetCheckoutAlias(ctx context.Context, alias string) (string, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	code, err := s.Client.Get(ctx, s.key("checkout_alias:%s", alias)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to get checkout alias from redis: %w", err)
+	}
+	return code, nil
+}
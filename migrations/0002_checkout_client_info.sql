@@ -0,0 +1,4 @@
+ALTER TABLE checkout_attempts ADD COLUMN IF NOT EXISTS client_ip VARCHAR(45);
+ALTER TABLE checkout_attempts ADD COLUMN IF NOT EXISTS user_agent VARCHAR(512);
+
+CREATE INDEX IF NOT EXISTS idx_checkout_attempts_sale_client_ip ON checkout_attempts(sale_id, client_ip);
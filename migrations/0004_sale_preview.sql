@@ -0,0 +1 @@
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS preview_start TIMESTAMP;
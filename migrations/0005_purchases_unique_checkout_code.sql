@@ -0,0 +1 @@
+CREATE UNIQUE INDEX IF NOT EXISTS idx_purchases_checkout_code_unique ON purchases(checkout_code);
@@ -0,0 +1,2 @@
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS title VARCHAR(255);
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS category VARCHAR(100);
@@ -0,0 +1,2 @@
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS max_items_per_user INTEGER;
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS duration_seconds INTEGER;
@@ -0,0 +1,6 @@
+-- Keep only the newest active sale so the unique index below can be built.
+UPDATE sales SET is_active = FALSE, updated_at = NOW()
+WHERE is_active = TRUE
+  AND id <> (SELECT MAX(id) FROM sales WHERE is_active = TRUE);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sales_single_active ON sales ((TRUE)) WHERE is_active = TRUE;
@@ -0,0 +1 @@
+ALTER TABLE items ADD COLUMN IF NOT EXISTS is_disabled BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_checkout_attempts_user_open ON checkout_attempts(user_id, sale_id, expires_at) WHERE is_used = FALSE;
@@ -0,0 +1,5 @@
+-- A prepared sale is created with its items ahead of the cycle boundary and
+-- activated in place when the cycle runs. At most one may exist at a time.
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS is_prepared BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sales_single_prepared ON sales ((TRUE)) WHERE is_prepared = TRUE;
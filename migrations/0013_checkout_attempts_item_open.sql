@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_checkout_attempts_item_open ON checkout_attempts(item_id, expires_at) WHERE is_used = FALSE;
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_purchases_user_purchased_at ON purchases(user_id, purchased_at);
@@ -0,0 +1,13 @@
+-- unsold_items on sales stays NULL until the sale has ended and its leftover
+-- inventory has been counted. The archive keeps the leftover items so
+-- restocking decisions survive later clean-up of the items table.
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS unsold_items INTEGER;
+
+CREATE TABLE IF NOT EXISTS unsold_items_archive (
+    sale_id BIGINT NOT NULL,
+    item_id BIGINT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    archived_at TIMESTAMP DEFAULT NOW(),
+    PRIMARY KEY (sale_id, item_id),
+    FOREIGN KEY (sale_id) REFERENCES sales(id) ON DELETE CASCADE
+);
@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_purchases_sale_purchased_at ON purchases(sale_id, purchased_at DESC);
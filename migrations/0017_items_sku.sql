@@ -0,0 +1,6 @@
+-- Items sharing a SKU are variants of one product; users may be limited in
+-- how many of a SKU they buy per sale. NULL means the item has no SKU.
+ALTER TABLE items ADD COLUMN IF NOT EXISTS sku VARCHAR(64);
+
+CREATE INDEX IF NOT EXISTS idx_items_sale_sku ON items(sale_id, sku);
+CREATE INDEX IF NOT EXISTS idx_purchases_user_sale ON purchases(user_id, sale_id);
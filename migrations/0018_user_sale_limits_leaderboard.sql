@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS idx_user_sale_limits_sale_purchased ON user_sale_limits(sale_id, items_purchased DESC);
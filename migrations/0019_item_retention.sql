@@ -0,0 +1,13 @@
+-- Items of old sales may be deleted under ITEM_RETENTION. Purchases outlive
+-- them, so they keep the item's name and no longer reference items by key.
+-- items_purged_at records when a sale's items were deleted.
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS item_name VARCHAR(255);
+
+UPDATE purchases p
+SET item_name = i.name
+FROM items i
+WHERE i.id = p.item_id AND p.item_name IS NULL;
+
+ALTER TABLE purchases DROP CONSTRAINT IF EXISTS purchases_item_id_fkey;
+
+ALTER TABLE sales ADD COLUMN IF NOT EXISTS items_purged_at TIMESTAMP;
@@ -0,0 +1,4 @@
+-- An item sells at most once. The purchase transaction already locks the
+-- item row; this makes the database refuse a second sale should that logic
+-- ever regress.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_purchases_item_id_unique ON purchases(item_id);
@@ -0,0 +1,7 @@
+-- Purchases record the payment reference they were verified with. A
+-- reference pays for one purchase only, so replaying it for a second code
+-- is refused by the database.
+ALTER TABLE purchases ADD COLUMN IF NOT EXISTS payment_reference VARCHAR(255);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_purchases_payment_reference_unique
+    ON purchases(payment_reference) WHERE payment_reference IS NOT NULL;
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migration files so the binary can apply
+// them without the directory being present on disk.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
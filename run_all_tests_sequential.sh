@@ -34,7 +34,7 @@ wait_for_services() {
     print_status "Waiting for services to be ready..."
     
     print_status "Waiting for API service..."
-    while ! curl -s http://localhost:8032/health >/dev/null 2>&1; do
+    while ! curl -s http://localhost:8032/healthz >/dev/null 2>&1; do
         sleep 1
     done
     